@@ -0,0 +1,44 @@
+package otelchi
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+)
+
+const (
+	baggageAttributePrefix = "baggage."
+
+	// maxBaggageValueLength is the max length of the recorded baggage
+	// values, since they come from upstream
+	maxBaggageValueLength = 256
+)
+
+// baggageAttributes returns span attributes for the given baggage members of
+// the context, the members are recorded with their own keys. When no key is
+// given, all members are recorded with the `baggage.` prefix.
+func baggageAttributes(ctx context.Context, keys []string) []attribute.KeyValue {
+	bag := baggage.FromContext(ctx)
+	if bag.Len() == 0 {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	if len(keys) == 0 {
+		for _, member := range bag.Members() {
+			attrs = append(attrs, attribute.String(
+				baggageAttributePrefix+member.Key(),
+				truncateString(member.Value(), maxBaggageValueLength),
+			))
+		}
+		return attrs
+	}
+	for _, key := range keys {
+		member := bag.Member(key)
+		if member.Key() == "" {
+			continue
+		}
+		attrs = append(attrs, attribute.String(key, truncateString(member.Value(), maxBaggageValueLength)))
+	}
+	return attrs
+}
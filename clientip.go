@@ -0,0 +1,126 @@
+package otelchi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+)
+
+// privateNetworks are the networks which addresses are never considered as
+// the client address when it is derived from the request headers.
+var privateNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+)
+
+// newTrustedProxies parses the given trusted proxy CIDRs. Invalid CIDRs are
+// reported to the global error handler and ignored.
+func newTrustedProxies(cidrs []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			otel.Handle(fmt.Errorf("invalid trusted proxy CIDR %q: %w", cidr, err))
+			continue
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks
+}
+
+// isTrustedProxy reports whether the request comes from one of the trusted
+// proxies, every request is trusted when there is no trusted proxy.
+func isTrustedProxy(trustedProxies []*net.IPNet, r *http.Request) bool {
+	if len(trustedProxies) == 0 {
+		return true
+	}
+	peer, _ := splitHostPort(r.RemoteAddr)
+	return containsIP(trustedProxies, net.ParseIP(peer))
+}
+
+// clientIPResolver derives the client address from the request headers set
+// by proxies (e.g X-Forwarded-For).
+type clientIPResolver struct {
+	headers        []string
+	trustedProxies []*net.IPNet
+}
+
+// newClientIPResolver returns nil when no header is configured.
+func newClientIPResolver(headers []string, trustedProxies []*net.IPNet) *clientIPResolver {
+	if len(headers) == 0 {
+		return nil
+	}
+	return &clientIPResolver{
+		headers:        headers,
+		trustedProxies: trustedProxies,
+	}
+}
+
+// clientIP returns the first public address found in the configured headers,
+// the headers are checked in the configured order. It falls back to the remote
+// address when no such address is found, or when the request doesn't come from
+// a trusted proxy.
+func (cr *clientIPResolver) clientIP(r *http.Request) string {
+	peer, _ := splitHostPort(r.RemoteAddr)
+	if !isTrustedProxy(cr.trustedProxies, r) {
+		return peer
+	}
+	for _, header := range cr.headers {
+		for _, value := range r.Header.Values(header) {
+			for _, entry := range strings.Split(value, ",") {
+				if ip := parseIP(entry); ip != nil && isPublicIP(ip) {
+					return ip.String()
+				}
+			}
+		}
+	}
+	return peer
+}
+
+// parseIP parses an address which may contain a port and IPv6 brackets, it
+// returns nil when the address is malformed.
+func parseIP(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	return !containsIP(privateNetworks, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks
+}
@@ -1,9 +1,12 @@
 package otelchi
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	otelmetric "go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	oteltrace "go.opentelemetry.io/otel/trace"
@@ -11,15 +14,111 @@ import (
 
 // config is used to configure the mux middleware.
 type config struct {
-	TracerProvider          oteltrace.TracerProvider
-	MeterProvider           otelmetric.MeterProvider
-	Propagators             propagation.TextMapPropagator
-	ChiRoutes               chi.Routes
-	RequestMethodInSpanName bool
-	Filter                  func(r *http.Request) bool
-	DisableMeasureInflight  bool
-	DisableMeasureSize      bool
-	TraceResponseHeaderKey  string
+	TracerProvider                oteltrace.TracerProvider
+	MeterProvider                 otelmetric.MeterProvider
+	Propagators                   propagation.TextMapPropagator
+	ChiRoutes                     chi.Routes
+	RequestMethodInSpanName       bool
+	Filter                        func(r *http.Request) bool
+	DisableMeasureInflight        bool
+	DisableMeasureSize            bool
+	TraceResponseHeaderKey        string
+	RecordOrigin                  bool
+	SessionCookieName             string
+	ResponseHeaders               []string
+	SemConvStability              SemConvStability
+	DisableRecordPanics           bool
+	HandlerErrorKey               interface{}
+	HeaderRedaction               func(name, value string) (string, bool)
+	DisableDefaultHeaderRedaction bool
+	ConcurrencyLimit              func(routePattern string) (current, limit int)
+	RequestHeaders                []string
+	URLParams                     []string
+	QueryParamRedaction           []string
+	DropQueryString               bool
+	CORSAttributes                bool
+	PeakWriteSizeAttribute        bool
+	DisableRequestContentLength   bool
+	ForceSampleRoutes             []string
+	ResponseContentLength         bool
+	DurationHistogramBoundaries   []float64
+	CookieCountAttribute          bool
+	ClientIPHeaders               []string
+	TrustedProxies                []string
+	MetricNamePrefix              string
+	DurationUnit                  DurationUnit
+	ForwardedHeader               bool
+	TracerProviderSelector        func(r *http.Request) oteltrace.TracerProvider
+	ExemplarRouteAttribute        bool
+	SchemeFromProxyHeaders        bool
+	RouteFilter                   func(routePattern string, r *http.Request) bool
+	SkipMethods                   []string
+	DeploymentSlot                string
+	MetricProtocolVersion         bool
+	DisableResponseWriterPool     bool
+	TLSAttributes                 bool
+	ClientIPResolver              func(r *http.Request) string
+	DisableUserAgent              bool
+	UserAgentMaxLength            int
+	TimeToFirstByteEvent          bool
+	PeerServiceHeader             string
+	PeerServiceMetricAttribute    bool
+	TracingDisabled               bool
+	EndUserExtractor              func(r *http.Request) (id string, role string, ok bool)
+	MetricsDisabled               bool
+	BaggageAttributes             bool
+	BaggageKeys                   []string
+	MetricsRecorder               MetricsRecorder
+	RequestIDAttribute            bool
+	RequestIDHeader               string
+	MeasureRequestCount           bool
+	ServerAddressAttributes       bool
+	CacheStatusHeader             string
+	CacheStatusMetricAttribute    bool
+	ErrorStatusCodes              []int
+	InjectResponseContext         bool
+	RateLimitAttributes           bool
+	RateLimitAttributesAlways     bool
+	RequestContentTypeAttribute   bool
+	ResponseContentTypeAttribute  bool
+	ContentTypeMetricAttributes   bool
+	AttributeValueLimit           int
+	InstrumentationName           string
+	InstrumentationVersion        string
+	SampleDecision                func(r *http.Request) bool
+	HTTPTarget                    bool
+	PublicEndpoint                bool
+	PublicEndpointFn              func(r *http.Request) bool
+	SpanNameFormatter             func(method, routePattern string, r *http.Request) string
+	UnmatchedRouteName            string
+	ServerNameResolver            func(r *http.Request) string
+	QueryStringAttribute          bool
+	QueryRedactor                 func(rawQuery string) string
+	TrimTrailingWildcard          bool
+	RouteParamsAttributes         bool
+	ServerNameInSpanName          bool
+	TrailerStatusKey              string
+	TrailerStatusFn               func(value string) (codes.Code, string)
+	TrimTrailingSlash             bool
+	RoutePatternTransformer       func(pattern string) string
+	SpanOptions                   []oteltrace.SpanStartOption
+	CORSPreflightSpanName         string
+	SkipCORSPreflightDuration     bool
+	NormalizeUnknownMethods       bool
+	KnownMethods                  []string
+	ErrorFromContext              func(ctx context.Context) error
+	HeadAsGet                     bool
+	DisableRepanic                bool
+	StrictStatusMapping           bool
+	SpanStatusMapper              func(status int) (codes.Code, string)
+	ClientDisconnects             bool
+	ClientClosedStatus            bool
+	RecordTimeouts                bool
+	GatewayTimeoutStatus          bool
+	MetricAttributes              func(p HTTPReqProperties, r *http.Request) []attribute.KeyValue
+	UnmatchedRouteLabel           string
+	RouteMetricID                 bool
+	RequestLogger                 func(info RequestInfo)
 }
 
 // Option specifies instrumentation configuration options.
@@ -62,6 +161,10 @@ func WithMeasureInflight(isDisabled bool) Option {
 	})
 }
 
+// WithMeasureSize is used for disabling the response size histogram. When it
+// is disabled and neither the response headers nor the peak write size are
+// captured, the response writer is wrapped with a lighter writer which only
+// records the status & the size of the response.
 func WithMeasureSize(isDisabled bool) Option {
 	return optionFunc(func(cfg *config) {
 		cfg.DisableMeasureSize = isDisabled
@@ -73,7 +176,10 @@ func WithMeasureSize(isDisabled bool) Option {
 // option is not set, by default the span will be given name at the end of span
 // execution. For some people, this behavior is not desirable since they want
 // to override the span name on underlying handler. By setting this option, it
-// is possible for them to override the span name.
+// is possible for them to override the span name, although SetSpanName works
+// without this option as well. When the request has already
+// been routed by chi (e.g the middleware is used inline with chi.With), the
+// route pattern of the existing route context is used instead.
 func WithChiRoutes(routes chi.Routes) Option {
 	return optionFunc(func(cfg *config) {
 		cfg.ChiRoutes = routes
@@ -110,3 +216,1028 @@ func WithTraceResponseHeaderKey(name string) Option {
 		cfg.TraceResponseHeaderKey = name
 	})
 }
+
+// WithOriginAttribute is used for recording the Origin request header as
+// span attribute. This is helpful for debugging CORS issues and for knowing
+// which frontends are calling the API. Requests without Origin header are
+// left untouched.
+func WithOriginAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RecordOrigin = isActive
+	})
+}
+
+// WithSessionCookieAttribute is used for grouping traces per session. When set,
+// the value of the cookie with the given name is hashed using SHA-256 and the
+// hex-encoded digest is recorded as span attribute. The raw cookie value is
+// never recorded.
+func WithSessionCookieAttribute(cookieName string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SessionCookieName = cookieName
+	})
+}
+
+// WithResponseHeaderAttributes is used for recording the given response
+// headers as span attributes named `http.response.header.<lowercased-key>`.
+// The headers are captured as they were when the response was written, so
+// later changes made by the handler to the header map are not reflected.
+// Headers that are not present in the response are skipped.
+func WithResponseHeaderAttributes(keys ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ResponseHeaders = keys
+	})
+}
+
+// WithSemConvStability is used for choosing which HTTP semantic conventions
+// are used for span & metric attributes. By default only the old conventions
+// are emitted. Use SemConvStabilityHTTPDup to emit both the old and the stable
+// conventions while migrating, or SemConvStabilityHTTP to only emit the
+// stable ones.
+func WithSemConvStability(mode SemConvStability) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SemConvStability = mode
+	})
+}
+
+// WithRecordPanics is used for recording panics raised by the handler. When
+// active, the panic is recovered, recorded as an exception event on the span,
+// the span status is set to error and then the panic is raised again so the
+// other recovery middlewares still work as usual. The response status is
+// considered as 500 for the metrics if nothing has been written yet, so they
+// are the same whether chi's middleware.Recoverer is placed before or after
+// this middleware. This is active by default.
+func WithRecordPanics(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableRecordPanics = !isActive
+	})
+}
+
+// WithHandlerErrorFromContext is used for recording the error produced by the
+// handler. After the handler returns, the request context value under the
+// given key is read, when it holds a non-nil error the error is recorded on the
+// span and the span status is set to error, even if the HTTP status code looks
+// fine.
+//
+// Since the handler can't modify the context seen by the middleware, the value
+// is usually an *error placed in the context by an outer middleware and filled
+// in by the handler. Plain error values are supported as well.
+func WithHandlerErrorFromContext(key interface{}) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.HandlerErrorKey = key
+	})
+}
+
+// WithHeaderRedaction is used for redacting the captured header values before
+// they are recorded as span attributes. The function receives the canonical
+// header name and one of its values, it returns the value to be recorded and
+// whether the value should be recorded at all. It is applied after the default
+// redaction, see WithDefaultHeaderRedaction.
+func WithHeaderRedaction(fn func(name, value string) (string, bool)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.HeaderRedaction = fn
+	})
+}
+
+// WithDefaultHeaderRedaction is used for toggling the default redaction of the
+// captured headers. When active, the values of Authorization, Cookie and
+// Set-Cookie headers are replaced with "REDACTED". This is active by default.
+func WithDefaultHeaderRedaction(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableDefaultHeaderRedaction = !isActive
+	})
+}
+
+// WithConcurrencyLimitAttribute is used for recording the utilization of the
+// route concurrency limiter. The function is called when the span is started,
+// it receives the route pattern and returns the current number of concurrent
+// requests and the limit for the route. Since the route pattern is only known
+// at span start when WithChiRoutes is set, the function receives empty string
+// otherwise.
+func WithConcurrencyLimitAttribute(fn func(routePattern string) (current, limit int)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ConcurrencyLimit = fn
+	})
+}
+
+// WithRequestHeaderAttributes is used for recording the given request headers
+// as span attributes named `http.request.header.<lowercased-key>`. Headers that
+// are not present in the request are skipped. The captured values go through
+// the same redaction as the response headers, see WithHeaderRedaction.
+func WithRequestHeaderAttributes(keys ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RequestHeaders = keys
+	})
+}
+
+// WithURLParamAttributes is used for recording the given chi URL params as span
+// attributes named `http.route.param.<name>`. Only the params listed here are
+// recorded, so it is possible to record low-cardinality params while leaving
+// out the others. Params that are not part of the matched route are skipped.
+func WithURLParamAttributes(names ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.URLParams = names
+	})
+}
+
+// WithQueryParamRedaction is used for redacting the values of the given query
+// params from the recorded request target (`http.target`) and query
+// (`url.query`) attributes. The values are replaced with "REDACTED", the
+// request itself is not modified.
+func WithQueryParamRedaction(params ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.QueryParamRedaction = params
+	})
+}
+
+// WithDropQueryString is used for removing the whole query string from the
+// recorded request target (`http.target`) and query (`url.query`) attributes.
+func WithDropQueryString(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DropQueryString = isActive
+	})
+}
+
+// WithCORSAttributes is used for recording CORS related span attributes.
+// Preflight requests (OPTIONS requests with Access-Control-Request-Method
+// header) are marked with `http.cors.preflight=true` along with the requested
+// method & headers, while actual cross-origin requests (requests with Origin
+// header) are marked with `http.cors.actual=true`.
+func WithCORSAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.CORSAttributes = isActive
+	})
+}
+
+// WithPeakWriteSizeAttribute is used for recording the size of the largest
+// single Write call made by the handler as `http.response.max_write_bytes`
+// span attribute. This helps to identify large single writes when tuning the
+// buffers of streaming responses.
+func WithPeakWriteSizeAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PeakWriteSizeAttribute = isActive
+	})
+}
+
+// WithRequestContentLengthAttribute is used for toggling the request content
+// length span attribute. When active, the content length of the request is
+// recorded whenever it is known, including zero length bodies. For requests
+// with unknown length (e.g chunked bodies) the number of bytes read by the
+// handler is recorded once the handler is done. This is active by default.
+func WithRequestContentLengthAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableRequestContentLength = !isActive
+	})
+}
+
+// WithForceSampleRoutes is used for marking the spans of the given route
+// patterns (e.g `/checkout/{id}`) to be always sampled regardless of the
+// global sampling ratio. The mark is only honored by the ForceSampleSampler of
+// the sampling subpackage, so it must be configured in the tracer provider.
+//
+// The mark is put on the span when it is created, so it only works when the
+// route pattern is known beforehand, which means WithChiRoutes must also be
+// set.
+func WithForceSampleRoutes(routes []string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ForceSampleRoutes = routes
+	})
+}
+
+// WithResponseContentLengthAttribute is used for toggling the response content
+// length span attribute. When active, the number of bytes written by the
+// handler is recorded once the handler is done. When the body bypassed Write
+// (e.g sendfile used by http.ServeContent), the value of the Content-Length
+// response header is recorded instead. This is inactive by default, see
+// WithBodySizeAttributes.
+func WithResponseContentLengthAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ResponseContentLength = isActive
+	})
+}
+
+// WithDurationHistogramBoundaries specifies the explicit bucket boundaries of
+// the request duration histogram, the boundaries are in the unit set by
+// WithDurationUnit (seconds by default). The boundaries must be strictly
+// increasing, otherwise the error is reported to the global error handler and
+// the default boundaries are used.
+func WithDurationHistogramBoundaries(boundaries []float64) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DurationHistogramBoundaries = boundaries
+	})
+}
+
+// WithCookieCountAttribute is used for recording the number of cookies sent
+// with the request as `http.request.cookie_count` span attribute. This is
+// helpful for spotting cookie bloat.
+func WithCookieCountAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.CookieCountAttribute = isActive
+	})
+}
+
+// WithClientIPFromHeaders is used for deriving the client address from the
+// given request headers set by proxies (e.g `X-Forwarded-For`,
+// `True-Client-IP`). The first public address found in the headers, checked
+// in the given order, overrides the `http.client_ip` (or `client.address` for
+// the stable semantic conventions) span attribute. Comma separated lists,
+// ports & IPv6 brackets are supported, when no valid public address is found
+// the remote address of the request is used.
+//
+// Since these headers can be spoofed by the client, use WithTrustedProxies to
+// restrict this to the requests coming from trusted proxies.
+func WithClientIPFromHeaders(headers ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ClientIPHeaders = headers
+	})
+}
+
+// WithTrustedProxies restricts WithClientIPFromHeaders, WithForwardedHeader &
+// WithSchemeFromProxyHeaders to the requests which remote address is within
+// the given CIDRs (e.g `10.0.0.0/8`), the remote address is used as the client
+// address for the other requests. Invalid CIDRs are reported to the global
+// error handler and ignored.
+func WithTrustedProxies(cidrs ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TrustedProxies = cidrs
+	})
+}
+
+// WithMetricNamePrefix specifies the prefix prepended as is to the name of
+// every emitted metric, e.g `myapp_` results in `myapp_requests_inflight`.
+func WithMetricNamePrefix(prefix string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricNamePrefix = prefix
+	})
+}
+
+// WithDurationUnit specifies the unit of the request duration metric, the
+// name of the metric follows the unit (`request_duration_seconds` or
+// `request_duration_milliseconds`). The default is DurationUnitSeconds, the
+// seconds are recorded as whole numbers in an integer histogram while the
+// milliseconds are recorded in a float histogram keeping their fraction.
+func WithDurationUnit(unit DurationUnit) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DurationUnit = unit
+	})
+}
+
+// WithForwardedHeader is used for parsing the Forwarded header (RFC 7239) set
+// by proxies. The client address (`for`), the original scheme (`proto`) & the
+// original host (`host`) taken from the first element having them override
+// the corresponding span attributes, they take precedence over the values
+// derived from the X-Forwarded-* headers. Unknown & obfuscated (e.g
+// `for=_hidden`) client identifiers are ignored.
+//
+// Just like WithClientIPFromHeaders, the header is only parsed for the
+// requests coming from the proxies set with WithTrustedProxies.
+func WithForwardedHeader(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ForwardedHeader = isActive
+	})
+}
+
+// WithTracerProviderSelector specifies a function selecting the tracer
+// provider used for the given request, e.g to isolate the traces of each
+// tenant. When the function returns nil, the tracer provider set with
+// WithTracerProvider (or the global one) is used. The tracers are cached per
+// provider, so the returned providers must be comparable (e.g pointers).
+func WithTracerProviderSelector(fn func(r *http.Request) oteltrace.TracerProvider) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TracerProviderSelector = fn
+	})
+}
+
+// WithExemplarRouteAttribute is used for adding the resolved route pattern as
+// `http.route` attribute to the request duration measurements. The duration is
+// recorded with the context of the server span, so the exemplars sampled by
+// the SDK point to a representative trace of the route. To keep the route out
+// of the data points, filter the attribute out with a view, the SDK keeps the
+// filtered attributes on the exemplars.
+func WithExemplarRouteAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ExemplarRouteAttribute = isActive
+	})
+}
+
+// WithSchemeFromProxyHeaders is used for recording the original scheme of the
+// request taken from the `Forwarded` (`proto=`) or the `X-Forwarded-Proto`
+// header, e.g when TLS terminates at the load balancer. The scheme is recorded
+// on the span (`http.scheme` or `url.scheme`) and on the metrics (`scheme` or
+// `url.scheme`). Unknown or missing values fall back to the scheme detected
+// from the TLS state of the request.
+//
+// Just like WithClientIPFromHeaders, the headers are only used for the
+// requests coming from the proxies set with WithTrustedProxies.
+func WithSchemeFromProxyHeaders(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SchemeFromProxyHeaders = isActive
+	})
+}
+
+// WithRouteFilter is used for filtering request that should not be traced
+// based on the matched chi route pattern. A RouteFilter must return true if
+// the request should be traced. The route pattern is only known when
+// WithChiRoutes is set, otherwise it is an empty string.
+//
+// It composes with WithFilter, the request is not traced when either of them
+// returns false.
+func WithRouteFilter(filter func(routePattern string, r *http.Request) bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RouteFilter = filter
+	})
+}
+
+// WithSkipMethods is used for skipping the tracing & metrics of the requests
+// with the given HTTP methods (e.g `OPTIONS`), the methods are matched case
+// insensitively.
+func WithSkipMethods(methods ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SkipMethods = methods
+	})
+}
+
+// WithDeploymentSlotAttribute is used for recording the deployment slot (e.g
+// `canary`, `stable`) as `deployment.slot` attribute of every span created by
+// the middleware. Unlike resource attributes, it is scoped to the middleware
+// instance, so two instances can be tagged differently.
+func WithDeploymentSlotAttribute(slot string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DeploymentSlot = slot
+	})
+}
+
+// WithProtocolVersionMetricAttribute is used for adding the HTTP protocol
+// version of the request (e.g `1.1`, `2`) to the metric attributes as `flavor`
+// (or `network.protocol.version` for the stable semantic conventions). The
+// version is always recorded on the span, it is opt-in for metrics since it
+// multiplies their cardinality.
+func WithProtocolVersionMetricAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricProtocolVersion = isActive
+	})
+}
+
+// WithResponseWriterPool is used for toggling the pooling of the response
+// writer wrappers. Pooling saves an allocation per request, disable it when
+// the request context (e.g StatusFromContext) is used after the request is
+// done. This is active by default.
+func WithResponseWriterPool(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableResponseWriterPool = !isActive
+	})
+}
+
+// WithTLSAttributes is used for recording the attributes of the TLS connection
+// of the request, which are the protocol version (`tls.protocol.version`), the
+// cipher suite (`tls.cipher`) & whether a client certificate was presented
+// (`tls.client.certificate_presented`). The certificate contents are never
+// recorded. Nothing is recorded for plain HTTP requests.
+func WithTLSAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TLSAttributes = isActive
+	})
+}
+
+// WithClientIPResolver specifies a function resolving the client address of
+// the request (e.g from `X-Real-IP`), the result overrides the `http.client_ip`
+// (or `client.address` for the stable semantic conventions) span attribute.
+// It takes precedence over WithClientIPFromHeaders & WithForwardedHeader. When
+// the function returns an empty string, the client address is left untouched.
+func WithClientIPResolver(fn func(r *http.Request) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ClientIPResolver = fn
+	})
+}
+
+// WithUserAgentAttribute is used for toggling the user agent span attributes
+// (`user_agent.original` along with `http.user_agent` for the old semantic
+// conventions). Requests without user agent don't get the attributes. This is
+// active by default.
+func WithUserAgentAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableUserAgent = !isActive
+	})
+}
+
+// WithUserAgentMaxLength is used for truncating the recorded user agent to at
+// most the given number of bytes, since some clients send very long user
+// agents. Zero (the default) means no truncation.
+func WithUserAgentMaxLength(maxLen int) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.UserAgentMaxLength = maxLen
+	})
+}
+
+// WithTimeToFirstByteEvent is used for adding the `http.first_write` span
+// event when the handler writes the response for the first time (either with
+// Write or WriteHeader). The event carries the elapsed time since the start of
+// the span in milliseconds as `http.first_write.elapsed_ms` attribute.
+func WithTimeToFirstByteEvent(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TimeToFirstByteEvent = isActive
+	})
+}
+
+// WithBodySizeAttributes is used for toggling both the request & the response
+// content length span attributes, it is the shorthand of
+// WithRequestContentLengthAttribute & WithResponseContentLengthAttribute. Only
+// the request content length is recorded by default, so the spans are not
+// bloated with attributes few users look at.
+func WithBodySizeAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableRequestContentLength = !isActive
+		cfg.ResponseContentLength = isActive
+	})
+}
+
+// WithPeerServiceFromHeader is used for recording the calling service taken
+// from the given request header (e.g `X-Calling-Service`) as `peer.service`
+// span attribute. The value is truncated to 64 bytes since it comes from the
+// callers.
+func WithPeerServiceFromHeader(headerName string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PeerServiceHeader = headerName
+	})
+}
+
+// WithPeerServiceMetricAttribute is used for adding the peer service set with
+// WithPeerServiceFromHeader to the metric attributes as `peer.service`. Only
+// use it when the header is set by trusted callers, since every distinct value
+// creates new metric series.
+func WithPeerServiceMetricAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PeerServiceMetricAttribute = isActive
+	})
+}
+
+// WithTracingDisabled is used for disabling the tracing of the requests while
+// still recording the metrics, no span is created and no span related work is
+// done. The route pattern is still resolved for the metric attributes.
+func WithTracingDisabled(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TracingDisabled = isActive
+	})
+}
+
+// WithEndUserExtractor specifies a function extracting the end user of the
+// request (e.g from the `sub` claim of a JWT), the returned id & role are
+// recorded as `enduser.id` & `enduser.role` span attributes, empty values are
+// not recorded. When the function returns false the span is left untouched.
+// The function is not called for the requests that are not traced.
+func WithEndUserExtractor(fn func(r *http.Request) (id string, role string, ok bool)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.EndUserExtractor = fn
+	})
+}
+
+// WithMetricsDisabled is used for disabling the metrics while still tracing
+// the requests, the meter provider is not used at all so no instrument is
+// created.
+func WithMetricsDisabled(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricsDisabled = isActive
+	})
+}
+
+// WithBaggageAttributes is used for recording the given members of the
+// baggage extracted from the request (e.g `tenant.id`) as span attributes with
+// the same keys, missing members are skipped. When no key is given, all
+// members are recorded with the `baggage.` prefix. The values are truncated to
+// 256 bytes. The baggage is only available when the propagators include the
+// Baggage propagator.
+func WithBaggageAttributes(keys ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.BaggageAttributes = true
+		cfg.BaggageKeys = keys
+	})
+}
+
+// WithMetricsRecorder specifies the recorder used for recording the metrics of
+// the requests instead of the default one, e.g to record custom metrics. Other
+// metrics related options only apply to the default recorder.
+func WithMetricsRecorder(recorder MetricsRecorder) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricsRecorder = recorder
+	})
+}
+
+// WithRequestIDAttribute is used for recording the request id as
+// `http.request_id` span attribute when the request starts. The id set by chi
+// RequestID middleware is used when the middleware is placed before this one,
+// otherwise the value of the given request header (e.g `X-Request-Id`) is
+// used. The header may be empty to only use chi RequestID middleware.
+//
+// The id generated by chi RequestID middleware placed after this one is never
+// recorded: it is only passed down to the handler, so place chi RequestID
+// middleware first when the incoming requests may lack the header.
+func WithRequestIDAttribute(fallbackHeader string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RequestIDAttribute = true
+		cfg.RequestIDHeader = fallbackHeader
+	})
+}
+
+// WithMeasureRequestCount specifies whether the number of requests should be
+// counted in the `requests_total` counter. The counter carries the
+// `status_class` attribute (e.g `2xx`, `5xx`) on top of the usual metric
+// attributes. It is disabled by default.
+func WithMeasureRequestCount(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MeasureRequestCount = isActive
+	})
+}
+
+// WithServerAddressAttributes specifies whether the `server.address` &
+// `server.port` attributes from the current semantic conventions should be
+// put to the span along with the old semantic conventions attributes. They
+// are derived from the Host header, the port of the listener is used when the
+// header doesn't carry one. The attributes are always emitted when the stable
+// semantic conventions are used (see WithSemConvStability).
+func WithServerAddressAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ServerAddressAttributes = isActive
+	})
+}
+
+// WithCacheStatusHeader is used for recording the value of the given response
+// header (e.g `X-Cache`) as `http.cache.status` span attribute. The value is
+// lowercased, nothing is recorded when the header is absent.
+func WithCacheStatusHeader(headerName string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.CacheStatusHeader = headerName
+	})
+}
+
+// WithCacheStatusMetricAttribute is used for adding the cache status recorded
+// with WithCacheStatusHeader to the attributes of the request duration
+// histogram as `http.cache.status`.
+func WithCacheStatusMetricAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.CacheStatusMetricAttribute = isActive
+	})
+}
+
+// WithErrorStatusCodes is used for marking the span status as error for the
+// given response status codes (e.g 404, 429) on top of the ones marked by the
+// semantic conventions.
+func WithErrorStatusCodes(statusCodes ...int) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ErrorStatusCodes = statusCodes
+	})
+}
+
+// WithInjectResponseContext specifies whether the span context should be
+// injected into the response headers using the configured propagators (e.g
+// the W3C `traceparent` header), so clients can correlate their requests with
+// the server traces.
+func WithInjectResponseContext(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.InjectResponseContext = isActive
+	})
+}
+
+// WithRateLimitAttributes specifies whether the rate limit response headers
+// (`Retry-After`, `X-RateLimit-*` & `RateLimit-*`) should be recorded as
+// `http.ratelimit.<header>` span attributes, e.g
+// `http.ratelimit.x-ratelimit-remaining`. Numeric values are recorded as
+// numbers. The headers are only captured for 429 & 503 responses unless
+// WithRateLimitAttributesAlways is active.
+func WithRateLimitAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RateLimitAttributes = isActive
+	})
+}
+
+// WithRateLimitAttributesAlways specifies whether the rate limit response
+// headers should be captured regardless of the response status code. It
+// implies WithRateLimitAttributes.
+func WithRateLimitAttributesAlways(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RateLimitAttributesAlways = isActive
+		if isActive {
+			cfg.RateLimitAttributes = true
+		}
+	})
+}
+
+// WithRequestContentTypeAttribute specifies whether the media type of the
+// request `Content-Type` header (e.g `application/json`) should be recorded as
+// `http.request.content_type` span attribute. The parameters of the media type
+// are stripped.
+func WithRequestContentTypeAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RequestContentTypeAttribute = isActive
+	})
+}
+
+// WithResponseContentTypeAttribute specifies whether the media type of the
+// response `Content-Type` header should be recorded as
+// `http.response.content_type` span attribute. The parameters of the media
+// type are stripped.
+func WithResponseContentTypeAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ResponseContentTypeAttribute = isActive
+	})
+}
+
+// WithContentTypeMetricAttributes is used for adding the content types
+// recorded with WithRequestContentTypeAttribute and
+// WithResponseContentTypeAttribute to the attributes of the request duration
+// histogram.
+func WithContentTypeMetricAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ContentTypeMetricAttributes = isActive
+	})
+}
+
+// WithAttributeValueLimit is used for truncating the string values of the span
+// attributes set by the middleware (e.g `http.target`, captured headers) to
+// the given number of bytes, "..." is appended to the truncated values. The
+// attributes set by the handler are not affected. Zero means no limit, which
+// is the default.
+func WithAttributeValueLimit(limit int) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.AttributeValueLimit = limit
+	})
+}
+
+// WithInstrumentationName is used for changing the instrumentation scope name
+// of the tracer & meter, e.g when the middleware is wrapped by another
+// library. The default is `github.com/riandyrn/otelchi`.
+func WithInstrumentationName(name string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.InstrumentationName = name
+	})
+}
+
+// WithInstrumentationVersion is used for changing the instrumentation scope
+// version of the tracer & meter. The default is the version of this package.
+func WithInstrumentationVersion(version string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.InstrumentationVersion = version
+	})
+}
+
+// WithSampleDecision is used for not sampling some of the requests (e.g
+// `/metrics`) while still recording their metrics. When the given function
+// returns false, no span is started and the handler gets a non-recording span
+// context with the sampled flag cleared, so the spans started by the handler
+// are not sampled either by parent based samplers. The decision overrides the
+// sampled flag of the propagated context. When it returns true, the sampling
+// is left to the sampler of the tracer provider.
+func WithSampleDecision(fn func(r *http.Request) bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SampleDecision = fn
+	})
+}
+
+// WithHTTPTarget specifies whether the full request target (path & query) as
+// sent by the client should always be recorded as `http.target` span
+// attribute, regardless of the used semantic conventions. The target is built
+// from the request URL when the request doesn't carry the original one. The
+// query is redacted according to WithQueryParamRedaction & WithDropQueryString.
+func WithHTTPTarget(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.HTTPTarget = isActive
+	})
+}
+
+// WithPublicEndpoint is used for marking the middleware as a public endpoint,
+// the span context propagated by the incoming requests is not trusted. A new
+// root span is started for each request and the propagated span context is
+// added to it as a link instead of being its parent.
+func WithPublicEndpoint(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PublicEndpoint = isActive
+	})
+}
+
+// WithPublicEndpointFn is the same as WithPublicEndpoint, but the given
+// function decides for each request whether it comes from a public endpoint.
+func WithPublicEndpointFn(fn func(r *http.Request) bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.PublicEndpointFn = fn
+	})
+}
+
+// WithSpanNameFormatter is used for setting the function that builds the span
+// name from the request method & the resolved route pattern, e.g to get span
+// names like `myservice:GET /users/{id}`. It replaces the default naming, so
+// WithRequestMethodInSpanName has no effect when it is set.
+func WithSpanNameFormatter(fn func(method, routePattern string, r *http.Request) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SpanNameFormatter = fn
+	})
+}
+
+// WithUnmatchedRouteName is used for changing the name used in place of the
+// route pattern when no route matches the request, the default is
+// `unmatched`. The span of such request is named after it just like after a
+// route pattern (e.g `GET unmatched` with WithRequestMethodInSpanName) and the
+// name is used as the `id` metric attribute, so random paths don't increase
+// the cardinality. The raw path is still recorded in the `http.target` span
+// attribute. Use WithUnmatchedRouteLabel for a different metric attribute.
+// The requests whose method is not allowed on an existing route (405) are not
+// unmatched, they are named after the route.
+func WithUnmatchedRouteName(name string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.UnmatchedRouteName = name
+	})
+}
+
+// WithServerNameResolver is used for resolving the server name of each
+// request, e.g from the Host header when serving several virtual hosts. The
+// resolved name is used for the `http.server_name` span attribute & the
+// `service` metric attribute. The server name given to Middleware is used
+// when the resolver returns an empty string.
+func WithServerNameResolver(fn func(r *http.Request) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ServerNameResolver = fn
+	})
+}
+
+// WithQueryStringAttribute specifies whether the raw query of the request
+// should be recorded as `url.query` span attribute when only the old semantic
+// conventions are used, the stable ones already carry it. Since the query may
+// contain PII, consider redacting it with WithQueryParamRedaction or
+// WithQueryRedactor.
+func WithQueryStringAttribute(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.QueryStringAttribute = isActive
+	})
+}
+
+// WithQueryRedactor is used for scrubbing the raw query before it is recorded
+// in the `http.target` & `url.query` span attributes. It is applied after the
+// redaction of WithQueryParamRedaction, the query is dropped when it returns
+// an empty string. The request itself is left untouched.
+func WithQueryRedactor(fn func(rawQuery string) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.QueryRedactor = fn
+	})
+}
+
+// WithTrimTrailingWildcard specifies whether the trailing `/*` of the route
+// patterns (e.g `/static/*`) should be removed from the span name, the
+// `http.route` attribute & the metric attributes. The root pattern `/*`
+// becomes `/`. The patterns given to WithForceSampleRoutes & WithRouteFilter
+// are matched against the trimmed patterns.
+func WithTrimTrailingWildcard(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TrimTrailingWildcard = isActive
+	})
+}
+
+// WithRouteParamsAttributes specifies whether all the chi URL params of the
+// matched route (including the `*` wildcard) should be recorded as span
+// attributes named `http.route.param.<name>`. Every param is recorded no
+// matter how many there are, use WithURLParamAttributes to only record some of
+// them.
+func WithRouteParamsAttributes(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RouteParamsAttributes = isActive
+	})
+}
+
+// WithServerNameInSpanName is used for prefixing the span name with the server
+// name given to Middleware, e.g `payments-api: GET /charges/{id}`. The prefix
+// is added on top of WithRequestMethodInSpanName & WithSpanNameFormatter.
+func WithServerNameInSpanName(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ServerNameInSpanName = isActive
+	})
+}
+
+// WithTrailerStatus is used for setting the span status from the given
+// response trailer (e.g `grpc-status` for gRPC-Web) instead of the HTTP status
+// code. The given function maps the trailer value to the span status & its
+// description, it is only called when the trailer is set by the handler,
+// either declared in the `Trailer` header or set with http.TrailerPrefix.
+func WithTrailerStatus(trailerKey string, fn func(value string) (codes.Code, string)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TrailerStatusKey = trailerKey
+		cfg.TrailerStatusFn = fn
+	})
+}
+
+// WithTrimTrailingSlash specifies whether a single trailing slash should be
+// removed from the route pattern used for the span name & the `http.route`
+// attribute, and from the `id` metric attribute, so `/users` and `/users/`
+// share the same span name & metric series. The root `/` is left as is. The
+// routing itself is not affected.
+func WithTrimTrailingSlash(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.TrimTrailingSlash = isActive
+	})
+}
+
+// WithRoutePatternTransformer is used for rewriting the resolved route pattern
+// before it is used for the span name, the `http.route` attribute & the
+// metric attributes, e.g to turn `/{id:[0-9]+}` into `/{id}`. The function
+// gets the full route pattern, including the prefixes of the mounted
+// sub-routers, and is called once per resolved pattern.
+func WithRoutePatternTransformer(fn func(pattern string) string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RoutePatternTransformer = fn
+	})
+}
+
+// WithSpanOptions is used for adding arbitrary start options to the span of
+// each request, e.g links or attributes, they are passed to the tracer Start
+// call as they are. The options are applied after the ones set by the
+// middleware, so they take precedence, including the span kind & the start
+// timestamp: avoid passing oteltrace.WithSpanKind unless the server kind
+// really has to be replaced.
+func WithSpanOptions(opts ...oteltrace.SpanStartOption) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SpanOptions = append(cfg.SpanOptions, opts...)
+	})
+}
+
+// WithCORSPreflightSpanName is used for naming the spans of the CORS preflight
+// requests (`OPTIONS` requests with `Access-Control-Request-Method` header)
+// with the given name (e.g `CORS preflight`) instead of the route pattern, so
+// they are grouped together. Other `OPTIONS` requests are named as usual.
+func WithCORSPreflightSpanName(name string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.CORSPreflightSpanName = name
+	})
+}
+
+// WithSkipCORSPreflightDuration specifies whether the duration of the CORS
+// preflight requests should be left out of the request duration histogram.
+func WithSkipCORSPreflightDuration(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SkipCORSPreflightDuration = isActive
+	})
+}
+
+// WithNormalizeUnknownMethods is used for replacing the unknown request
+// methods (e.g `PROPFIND` or made-up methods sent by scanners) with `_OTHER`
+// in the span name, the method span attribute & the metrics, to keep their
+// cardinality bounded. The original method is recorded in the
+// `http.request.method_original` span attribute. The known methods are the
+// standard ones & the ones given to WithKnownMethods.
+func WithNormalizeUnknownMethods(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.NormalizeUnknownMethods = isActive
+	})
+}
+
+// WithKnownMethods is used for adding methods (e.g the ones registered via
+// chi.RegisterMethod) to the known methods of WithNormalizeUnknownMethods.
+func WithKnownMethods(methods ...string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.KnownMethods = methods
+	})
+}
+
+// WithErrorFromContext is used for recording the error produced by the handler
+// when it is stored in the request context in a way not covered by
+// WithHandlerErrorFromContext. After the handler returns, the given function
+// is called with the request context, when it returns a non-nil error the
+// error is recorded on the span and the span status is set to error, even if
+// the HTTP status code looks fine. It takes precedence over
+// WithHandlerErrorFromContext.
+func WithErrorFromContext(fn func(ctx context.Context) error) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ErrorFromContext = fn
+	})
+}
+
+// WithHeadAsGet is used for matching the HEAD requests against the GET routes
+// given to WithChiRoutes when they don't match any HEAD route, which is how
+// they are routed by chi's middleware.GetHead. This way the HEAD requests get
+// the same route pattern as their GET counterparts in the span name & the
+// metrics, while the span still records the HEAD method.
+func WithHeadAsGet(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.HeadAsGet = isActive
+	})
+}
+
+// WithRepanic specifies whether the panics recorded by WithRecordPanics are
+// raised again once recorded, so the other recovery middlewares & the server
+// still see them. When it is disabled, the middleware is the recoverer of last
+// resort: the panic is swallowed and the 500 status is written if the handler
+// hasn't written the response yet. This is active by default.
+func WithRepanic(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.DisableRepanic = !isActive
+	})
+}
+
+// WithStrictStatusMapping is used for mapping the response status code to the
+// span status as specified by the OpenTelemetry specification for the server
+// spans: the 4xx responses (including the nonstandard ones such as `499`) are
+// caused by the client, so they leave the span status unset. The 5xx
+// responses still set the span status to error.
+func WithStrictStatusMapping(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.StrictStatusMapping = isActive
+	})
+}
+
+// WithSpanStatusMapper is used for mapping the response status code to the
+// span status with the given function instead of the built-in mapping, the
+// returned status & description are applied as they are. The function receives
+// 200 when the handler didn't write the response. WithStrictStatusMapping &
+// WithErrorStatusCodes have no effect when it is set.
+func WithSpanStatusMapper(fn func(status int) (codes.Code, string)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.SpanStatusMapper = fn
+	})
+}
+
+// WithClientDisconnects is used for recording the requests which client went
+// away before the handler was done (the request context is canceled). The
+// `client.disconnected` event & the `http.client_closed_request` attribute
+// are added to the span.
+func WithClientDisconnects(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ClientDisconnects = isActive
+	})
+}
+
+// WithClientClosedStatus is used for recording the `499` status in the
+// metrics of the requests detected by WithClientDisconnects, unless the
+// handler already wrote the response status.
+func WithClientClosedStatus(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.ClientClosedStatus = isActive
+	})
+}
+
+// WithRecordTimeouts is used for recording the requests which deadline is
+// exceeded, e.g the ones timed out by chi's middleware.Timeout placed before
+// this middleware. The `timeout` event is added to the span along with the
+// `http.request.timeout_ms` attribute when the deadline is known, and the
+// span status is set to error. The timed out requests are counted in the
+// `requests_timeout` counter as well, along with their `http.route`
+// attribute. See WithGatewayTimeoutStatus when middleware.Timeout is placed
+// after this middleware.
+func WithRecordTimeouts(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RecordTimeouts = isActive
+	})
+}
+
+// WithGatewayTimeoutStatus is used for considering the requests responded
+// with 504 as timed out when WithRecordTimeouts is active. The deadline set by
+// chi's middleware.Timeout placed after this middleware is not visible here,
+// only its 504 response is, but the handlers (e.g proxies) may respond with
+// 504 on their own as well.
+func WithGatewayTimeoutStatus(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.GatewayTimeoutStatus = isActive
+	})
+}
+
+// WithMetricAttributes is used for replacing the default attributes of the
+// metrics (service, route, method, status code, etc...) with the ones returned
+// by the given function, e.g for dropping the route of high cardinality
+// endpoints or adding a custom attribute. The function is called once the
+// handler is done, and when the request starts for the in-flight requests
+// metric (the status code is unknown then). The attributes added by the
+// options specific to a metric (e.g WithCacheStatusMetricAttribute) are still
+// added on top of the returned ones. It is ignored by the custom recorders
+// given to WithMetricsRecorder.
+func WithMetricAttributes(fn func(p HTTPReqProperties, r *http.Request) []attribute.KeyValue) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.MetricAttributes = fn
+	})
+}
+
+// WithUnmatchedRouteLabel is used for changing the `id` metric attribute of
+// the requests which no route matches, independently of the span name set by
+// WithUnmatchedRouteName. It defaults to the unmatched route name. When it is
+// set, the in-flight requests metric never sees the request path either: its
+// `id` is the label when WithChiRoutes doesn't match the request, and it is
+// empty when the route is unknown until the handler is done.
+func WithUnmatchedRouteLabel(label string) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.UnmatchedRouteLabel = label
+	})
+}
+
+// WithRouteMetricID is used for making sure the metrics never see the raw
+// request path: the `id` metric attribute (see HTTPReqProperties.ID) is the
+// resolved route pattern, or the unmatched route label when no route matches
+// the request. Without WithChiRoutes, the route is unknown when the request
+// starts, so the `id` of the in-flight requests metric is empty. The spans
+// are not affected.
+func WithRouteMetricID(isActive bool) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RouteMetricID = isActive
+	})
+}
+
+// WithRequestLogger is used for calling the given function once each request
+// is done, e.g for emitting access logs correlated with the traces without a
+// separate middleware. The function is called for the sampled out requests as
+// well, the trace & span IDs are invalid when tracing is disabled.
+func WithRequestLogger(fn func(info RequestInfo)) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.RequestLogger = fn
+	})
+}
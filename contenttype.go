@@ -0,0 +1,31 @@
+package otelchi
+
+import (
+	"mime"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxContentTypeLength is the max length of the recorded content type when
+// it is not a valid media type.
+const maxContentTypeLength = 64
+
+var (
+	requestContentTypeKey  = attribute.Key("http.request.content_type")
+	responseContentTypeKey = attribute.Key("http.response.content_type")
+)
+
+// mediaType returns the media type of the given Content-Type header value
+// without its parameters (e.g `application/json`), the truncated raw value is
+// returned when it is malformed.
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return truncateString(strings.TrimSpace(contentType), maxContentTypeLength)
+	}
+	return mt
+}
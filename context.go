@@ -0,0 +1,69 @@
+package otelchi
+
+import (
+	"context"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// rrwContextKey is the context key of the recordingResponseWriter of the
+// current request.
+type rrwContextKey struct{}
+
+func rrwFromContext(ctx context.Context) *recordingResponseWriter {
+	rrw, _ := ctx.Value(rrwContextKey{}).(*recordingResponseWriter)
+	return rrw
+}
+
+// StatusFromContext returns the response status code recorded by the
+// middleware for the current request. It returns false when the context
+// doesn't come from the middleware or when the response hasn't been written
+// yet.
+//
+// The value is only meaningful once the handler has written the response, so
+// it is most useful when read after calling the next handler, e.g in a
+// deferred function of a middleware placed after this one. The context must
+// not be used once the request is done.
+func StatusFromContext(ctx context.Context) (int, bool) {
+	rrw := rrwFromContext(ctx)
+	if rrw == nil || !rrw.written {
+		return 0, false
+	}
+	return rrw.status, true
+}
+
+// BytesWrittenFromContext returns the number of response body bytes recorded
+// by the middleware for the current request. It returns false when the context
+// doesn't come from the middleware.
+//
+// Just like StatusFromContext, the value is most useful when read after
+// calling the next handler, and the context must not be used once the request
+// is done.
+func BytesWrittenFromContext(ctx context.Context) (int64, bool) {
+	rrw := rrwFromContext(ctx)
+	if rrw == nil {
+		return 0, false
+	}
+	return rrw.writtenBytes, true
+}
+
+// TraceIDFromContext returns the hex-encoded trace id of the span carried by
+// the given context, e.g for logging. It returns false when the span is not
+// recording.
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	span := oteltrace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return "", false
+	}
+	return span.SpanContext().TraceID().String(), true
+}
+
+// SpanIDFromContext returns the hex-encoded span id of the span carried by the
+// given context. It returns false when the span is not recording.
+func SpanIDFromContext(ctx context.Context) (string, bool) {
+	span := oteltrace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return "", false
+	}
+	return span.SpanContext().SpanID().String(), true
+}
@@ -0,0 +1,177 @@
+package otelchi
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// forwarded holds the parameters of the Forwarded header (RFC 7239), every
+// parameter is taken from the first element that has it.
+type forwarded struct {
+	forNode string
+	proto   string
+	host    string
+}
+
+// parseForwarded parses the given values of the Forwarded header, malformed
+// pairs are ignored.
+func parseForwarded(values []string) forwarded {
+	var fwd forwarded
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			for _, pair := range splitQuoted(element, ';') {
+				i := strings.Index(pair, "=")
+				if i < 0 {
+					continue
+				}
+				val := unquote(strings.TrimSpace(pair[i+1:]))
+				switch strings.ToLower(strings.TrimSpace(pair[:i])) {
+				case "for":
+					if fwd.forNode == "" {
+						fwd.forNode = val
+					}
+				case "proto":
+					if fwd.proto == "" {
+						fwd.proto = strings.ToLower(val)
+					}
+				case "host":
+					if fwd.host == "" {
+						fwd.host = val
+					}
+				}
+			}
+		}
+	}
+	return fwd
+}
+
+// clientAddress returns the address of the `for` node, it returns an empty
+// string for unknown & obfuscated (e.g `_hidden`) identifiers.
+func (fwd forwarded) clientAddress() string {
+	if fwd.forNode == "" || fwd.forNode == "unknown" || strings.HasPrefix(fwd.forNode, "_") {
+		return ""
+	}
+	ip := parseIP(fwd.forNode)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// forwardedAttributes returns the client address, original scheme & original
+// host span attributes from the Forwarded header of the request.
+func forwardedAttributes(r *http.Request, semConvStability SemConvStability) []attribute.KeyValue {
+	values := r.Header.Values("Forwarded")
+	if len(values) == 0 {
+		return nil
+	}
+	fwd := parseForwarded(values)
+
+	var attrs []attribute.KeyValue
+	if clientAddr := fwd.clientAddress(); clientAddr != "" {
+		if semConvStability.emitOld() {
+			attrs = append(attrs, semconv.HTTPClientIPKey.String(clientAddr))
+		}
+		if semConvStability.emitStable() {
+			attrs = append(attrs, semconvstable.ClientAddress(clientAddr))
+		}
+	}
+	if fwd.proto != "" {
+		if semConvStability.emitOld() {
+			attrs = append(attrs, semconv.HTTPSchemeKey.String(fwd.proto))
+		}
+		if semConvStability.emitStable() {
+			attrs = append(attrs, semconvstable.URLScheme(fwd.proto))
+		}
+	}
+	if fwd.host != "" {
+		if semConvStability.emitOld() {
+			attrs = append(attrs, semconv.HTTPHostKey.String(fwd.host))
+		}
+		if semConvStability.emitStable() {
+			if host, port := splitHostPort(fwd.host); host != "" {
+				attrs = append(attrs, semconvstable.ServerAddress(host))
+				if port > 0 {
+					attrs = append(attrs, semconvstable.ServerPort(port))
+				}
+			}
+		}
+	}
+	return attrs
+}
+
+// splitQuoted splits s by sep, separators within quoted strings are ignored.
+func splitQuoted(s string, sep byte) []string {
+	var (
+		parts    []string
+		inQuotes bool
+		escaped  bool
+		start    int
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && inQuotes:
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote removes the quotes of a quoted string along with its escapes, other
+// values are returned as is.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// proxyScheme returns the original scheme of the request from the Forwarded
+// header or the X-Forwarded-Proto header, the Forwarded header takes
+// precedence. It returns an empty string when the scheme is missing or is not
+// a known one.
+func proxyScheme(r *http.Request) string {
+	scheme := ""
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		scheme = parseForwarded(values).proto
+	}
+	if scheme == "" {
+		scheme = r.Header.Get("X-Forwarded-Proto")
+		if i := strings.Index(scheme, ","); i >= 0 {
+			scheme = scheme[:i]
+		}
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+	}
+	if scheme != "http" && scheme != "https" {
+		return ""
+	}
+	return scheme
+}
+
+// requestScheme returns the scheme of the request detected from its TLS
+// state.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,113 @@
+package otelchi
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	requestHeaderAttributePrefix  = "http.request.header."
+	responseHeaderAttributePrefix = "http.response.header."
+
+	redactedValue = "REDACTED"
+)
+
+// defaultRedactedHeaders are the headers which values are redacted by default
+// since they usually carry credentials.
+var defaultRedactedHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+// headerRedactor is called for each captured header value before it is put
+// into span attributes. It returns the value to record and whether the value
+// should be recorded at all.
+type headerRedactor func(name, value string) (string, bool)
+
+func defaultHeaderRedaction(name, value string) (string, bool) {
+	if defaultRedactedHeaders[name] {
+		return redactedValue, true
+	}
+	return value, true
+}
+
+// newHeaderRedactor combines the default redaction with the user provided
+// one, the default redaction is applied first.
+func newHeaderRedactor(useDefault bool, custom func(name, value string) (string, bool)) headerRedactor {
+	switch {
+	case useDefault && custom != nil:
+		return func(name, value string) (string, bool) {
+			value, ok := defaultHeaderRedaction(name, value)
+			if !ok {
+				return "", false
+			}
+			return custom(name, value)
+		}
+	case useDefault:
+		return defaultHeaderRedaction
+	}
+	return custom
+}
+
+// headerAttribute maps a captured header to its span attribute key.
+type headerAttribute struct {
+	name string
+	key  attribute.Key
+}
+
+func newHeaderAttributes(prefix string, names []string) []headerAttribute {
+	if len(names) == 0 {
+		return nil
+	}
+	attrs := make([]headerAttribute, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, headerAttribute{
+			name: http.CanonicalHeaderKey(name),
+			key:  attribute.Key(prefix + strings.ToLower(name)),
+		})
+	}
+	return attrs
+}
+
+// snapshotHeader copies the values of the captured headers, so later
+// mutations of the original header map won't affect them.
+func snapshotHeader(headerAttrs []headerAttribute, header http.Header) http.Header {
+	snapshot := make(http.Header, len(headerAttrs))
+	for _, ha := range headerAttrs {
+		if values := header.Values(ha.name); len(values) > 0 {
+			snapshot[ha.name] = append([]string(nil), values...)
+		}
+	}
+	return snapshot
+}
+
+// headerAttributesFrom returns span attributes for the captured headers that
+// are present in the given header. Every value goes through the redactor when
+// it is not nil.
+func headerAttributesFrom(headerAttrs []headerAttribute, header http.Header, redact headerRedactor) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, ha := range headerAttrs {
+		values := header.Values(ha.name)
+		if redact != nil {
+			values = redactHeaderValues(ha.name, values, redact)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		attrs = append(attrs, ha.key.StringSlice(values))
+	}
+	return attrs
+}
+
+func redactHeaderValues(name string, values []string, redact headerRedactor) []string {
+	redacted := make([]string, 0, len(values))
+	for _, value := range values {
+		if value, ok := redact(name, value); ok {
+			redacted = append(redacted, value)
+		}
+	}
+	return redacted
+}
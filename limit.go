@@ -0,0 +1,67 @@
+package otelchi
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// truncatedMarker is appended to the string attribute values truncated by
+// WithAttributeValueLimit.
+const truncatedMarker = "..."
+
+// limitAttributeValues truncates the string values of the given attributes to
+// limit bytes in place, limit must be positive.
+func limitAttributeValues(attrs []attribute.KeyValue, limit int) []attribute.KeyValue {
+	for i, attr := range attrs {
+		switch attr.Value.Type() {
+		case attribute.STRING:
+			if s := attr.Value.AsString(); len(s) > limit {
+				attrs[i] = attr.Key.String(truncateString(s, limit) + truncatedMarker)
+			}
+		case attribute.STRINGSLICE:
+			values := attr.Value.AsStringSlice()
+			truncated := false
+			for j, s := range values {
+				if len(s) > limit {
+					values[j] = truncateString(s, limit) + truncatedMarker
+					truncated = true
+				}
+			}
+			if truncated {
+				attrs[i] = attr.Key.StringSlice(values)
+			}
+		}
+	}
+	return attrs
+}
+
+// limitSpanStartOptions applies the limit to the attributes of the given span
+// start options.
+func limitSpanStartOptions(opts []oteltrace.SpanStartOption, limit int) []oteltrace.SpanStartOption {
+	spanCfg := oteltrace.NewSpanStartConfig(opts...)
+	limited := []oteltrace.SpanStartOption{
+		oteltrace.WithSpanKind(spanCfg.SpanKind()),
+		oteltrace.WithAttributes(limitAttributeValues(spanCfg.Attributes(), limit)...),
+	}
+	if !spanCfg.Timestamp().IsZero() {
+		limited = append(limited, oteltrace.WithTimestamp(spanCfg.Timestamp()))
+	}
+	if spanCfg.NewRoot() {
+		limited = append(limited, oteltrace.WithNewRoot())
+	}
+	if links := spanCfg.Links(); len(links) > 0 {
+		limited = append(limited, oteltrace.WithLinks(links...))
+	}
+	return limited
+}
+
+// limitedSpan truncates the string values of the attributes set by the
+// middleware, the span given to the handler is left untouched.
+type limitedSpan struct {
+	oteltrace.Span
+	limit int
+}
+
+func (s *limitedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.Span.SetAttributes(limitAttributeValues(kv, s.limit)...)
+}
@@ -0,0 +1,73 @@
+package otelchi
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// otherMethod replaces the request methods which are not known when
+// WithNormalizeUnknownMethods is active, as specified by the HTTP semantic
+// conventions.
+const otherMethod = "_OTHER"
+
+// standardMethods are the methods defined in RFC 9110 & RFC 5789.
+var standardMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// newKnownMethods returns the set of the standard methods extended with the
+// given methods, or nil when the normalization is not active.
+func newKnownMethods(isActive bool, extra []string) map[string]bool {
+	if !isActive {
+		return nil
+	}
+	res := make(map[string]bool, len(standardMethods)+len(extra))
+	for _, method := range standardMethods {
+		res[method] = true
+	}
+	for _, method := range extra {
+		// chi.RegisterMethod uppercases the methods as well
+		res[strings.ToUpper(method)] = true
+	}
+	return res
+}
+
+// requestMethod returns the method of the request used for the span name &
+// the metrics, which is `_OTHER` for the unknown methods when
+// WithNormalizeUnknownMethods is active.
+func (ow *otelware) requestMethod(r *http.Request) string {
+	if ow.knownMethods == nil || ow.knownMethods[r.Method] {
+		return r.Method
+	}
+	return otherMethod
+}
+
+// methodAttributes returns the attributes overriding the method attributes of
+// the semantic conventions for the unknown methods, the original method is
+// kept in `http.request.method_original`.
+func (ow *otelware) methodAttributes(r *http.Request) []attribute.KeyValue {
+	method := ow.requestMethod(r)
+	if method == r.Method {
+		return nil
+	}
+	var attrs []attribute.KeyValue
+	if ow.semConvStability.emitOld() {
+		attrs = append(attrs, semconv.HTTPMethodKey.String(method))
+	}
+	if ow.semConvStability.emitStable() {
+		attrs = append(attrs, semconvstable.HTTPRequestMethodKey.String(method))
+	}
+	return append(attrs, semconvstable.HTTPRequestMethodOriginal(r.Method))
+}
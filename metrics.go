@@ -3,10 +3,14 @@ package otelchi
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	otelmetric "go.opentelemetry.io/otel/metric"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
 var (
@@ -14,74 +18,306 @@ var (
 	idKey      = attribute.Key("id")
 	methodKey  = attribute.Key("method")
 	codeKey    = attribute.Key("code")
+	schemeKey  = attribute.Key("scheme")
+	flavorKey  = attribute.Key("flavor")
+
+	statusClassKey = attribute.Key("status_class")
 )
 
-type httpReqProperties struct {
+// MetricsRecorder records the metrics of the requests handled by the
+// middleware. Implement it and pass it to WithMetricsRecorder to record custom
+// metrics instead of the default ones.
+type MetricsRecorder interface {
+	// RecordRequestsInflight is called with 1 when the request starts and
+	// with -1 when it is done.
+	RecordRequestsInflight(ctx context.Context, p HTTPReqProperties, count int64)
+	// RecordRequestDuration is called once the handler is done.
+	RecordRequestDuration(ctx context.Context, p HTTPReqProperties, duration time.Duration)
+	// RecordResponseSize is called once the handler is done with the number
+	// of bytes written by the handler.
+	RecordResponseSize(ctx context.Context, p HTTPReqProperties, size int64)
+}
+
+// HTTPReqProperties holds the properties of the request passed to the
+// MetricsRecorder.
+type HTTPReqProperties struct {
+	// Service is the server name given to Middleware
 	Service string
-	ID      string
-	Method  string
-	Code    int
+	// ID is the route pattern when it is known before the handler is
+	// executed (see WithChiRoutes), otherwise it is the request path. It is
+	// never the request path when WithRouteMetricID is active.
+	ID string
+	// Method is the request method
+	Method string
+	// Code is the response status code, it is only known once the handler
+	// is done
+	Code int
+
+	// Route is the resolved route pattern, it is only known once the
+	// handler is done
+	Route string
+
+	// Scheme is the original scheme of the request, it is only set when
+	// WithSchemeFromProxyHeaders is active
+	Scheme string
+
+	// ProtocolVersion is the HTTP protocol version of the request, it is
+	// only set when WithProtocolVersionMetricAttribute is active
+	ProtocolVersion string
+
+	// PeerService is the calling service, it is only set when
+	// WithPeerServiceMetricAttribute is active
+	PeerService string
+
+	// CacheStatus is the lowercased value of the cache status response
+	// header, it is only set when WithCacheStatusMetricAttribute is active
+	CacheStatus string
+
+	// RequestContentType & ResponseContentType are the media types of the
+	// request & response, they are only set when
+	// WithContentTypeMetricAttributes is active
+	RequestContentType  string
+	ResponseContentType string
+
+	// TimedOut reports whether the request deadline is exceeded, it is only
+	// set when WithRecordTimeouts is active
+	TimedOut bool
+
+	// attrs are the attributes returned by the function given to
+	// WithMetricAttributes, they replace the default ones when non-nil
+	attrs []attribute.KeyValue
+}
+
+// DurationUnit specifies the unit of the recorded request duration.
+type DurationUnit int
+
+const (
+	// DurationUnitSeconds records the request duration in seconds in the
+	// `request_duration_seconds` histogram. This is the default.
+	DurationUnitSeconds DurationUnit = iota
+	// DurationUnitMilliseconds records the request duration in milliseconds
+	// in the `request_duration_milliseconds` histogram.
+	DurationUnitMilliseconds
+)
+
+func (u DurationUnit) metricName() string {
+	if u == DurationUnitMilliseconds {
+		return "request_duration_milliseconds"
+	}
+	return "request_duration_seconds"
 }
 
-func newMetricsRecorder(meter otelmetric.Meter) *metricsRecorder {
-	httpRequestDurHistogram, err := meter.Int64Histogram("request_duration_seconds")
+func newMetricsRecorder(meter otelmetric.Meter, cfg config) *metricsRecorder {
+	durationName := cfg.MetricNamePrefix + cfg.DurationUnit.metricName()
+	var durationBoundaries []float64
+	if len(cfg.DurationHistogramBoundaries) > 0 {
+		if err := validateHistogramBoundaries(cfg.DurationHistogramBoundaries); err != nil {
+			// fallback to the default boundaries
+			otel.Handle(fmt.Errorf("invalid %s histogram boundaries: %w", durationName, err))
+		} else {
+			durationBoundaries = cfg.DurationHistogramBoundaries
+		}
+	}
+
+	// the seconds are recorded as integers like they always were, only the
+	// milliseconds keep their fractional part
+	var (
+		httpRequestDurHistogram   otelmetric.Int64Histogram
+		httpRequestDurMsHistogram otelmetric.Float64Histogram
+		err                       error
+	)
+	if cfg.DurationUnit == DurationUnitMilliseconds {
+		var durationOpts []otelmetric.Float64HistogramOption
+		if durationBoundaries != nil {
+			durationOpts = append(durationOpts, otelmetric.WithExplicitBucketBoundaries(durationBoundaries...))
+		}
+		httpRequestDurMsHistogram, err = meter.Float64Histogram(durationName, durationOpts...)
+	} else {
+		var durationOpts []otelmetric.Int64HistogramOption
+		if durationBoundaries != nil {
+			durationOpts = append(durationOpts, otelmetric.WithExplicitBucketBoundaries(durationBoundaries...))
+		}
+		httpRequestDurHistogram, err = meter.Int64Histogram(durationName, durationOpts...)
+	}
 	if err != nil {
-		panic(fmt.Sprintf("failed to create request_duration_seconds histogram: %v", err))
+		panic(fmt.Sprintf("failed to create %s histogram: %v", durationName, err))
 	}
 
-	httpResponseSizeHistogram, err := meter.Int64Histogram("response_size_bytes")
+	responseSizeName := cfg.MetricNamePrefix + "response_size_bytes"
+	httpResponseSizeHistogram, err := meter.Int64Histogram(responseSizeName)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create response_size_bytes histogram: %v", err))
+		panic(fmt.Sprintf("failed to create %s histogram: %v", responseSizeName, err))
 	}
 
-	httpRequestsInflight, err := meter.Int64UpDownCounter("requests_inflight")
+	inflightName := cfg.MetricNamePrefix + "requests_inflight"
+	httpRequestsInflight, err := meter.Int64UpDownCounter(inflightName)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create requests_inflight counter: %v", err))
+		panic(fmt.Sprintf("failed to create %s counter: %v", inflightName, err))
+	}
+
+	var httpRequestsCounter otelmetric.Int64Counter
+	if cfg.MeasureRequestCount {
+		requestsName := cfg.MetricNamePrefix + "requests_total"
+		httpRequestsCounter, err = meter.Int64Counter(requestsName)
+		if err != nil {
+			panic(fmt.Sprintf("failed to create %s counter: %v", requestsName, err))
+		}
+	}
+
+	var httpTimeoutsCounter otelmetric.Int64Counter
+	if cfg.RecordTimeouts {
+		timeoutsName := cfg.MetricNamePrefix + "requests_timeout"
+		httpTimeoutsCounter, err = meter.Int64Counter(timeoutsName)
+		if err != nil {
+			panic(fmt.Sprintf("failed to create %s counter: %v", timeoutsName, err))
+		}
 	}
 
 	return &metricsRecorder{
 		httpRequestDurHistogram:   httpRequestDurHistogram,
+		httpRequestDurMsHistogram: httpRequestDurMsHistogram,
 		httpResponseSizeHistogram: httpResponseSizeHistogram,
 		httpRequestsInflight:      httpRequestsInflight,
+		httpRequestsCounter:       httpRequestsCounter,
+		httpTimeoutsCounter:       httpTimeoutsCounter,
+		semConvStability:          cfg.SemConvStability,
+		exemplarRouteAttribute:    cfg.ExemplarRouteAttribute,
 	}
 }
 
 type metricsRecorder struct {
 	httpRequestDurHistogram   otelmetric.Int64Histogram
+	httpRequestDurMsHistogram otelmetric.Float64Histogram
 	httpResponseSizeHistogram otelmetric.Int64Histogram
 	httpRequestsInflight      otelmetric.Int64UpDownCounter
+	httpRequestsCounter       otelmetric.Int64Counter
+	httpTimeoutsCounter       otelmetric.Int64Counter
+	semConvStability          SemConvStability
+	exemplarRouteAttribute    bool
+}
+
+// validateHistogramBoundaries checks that the given boundaries are strictly
+// increasing.
+func validateHistogramBoundaries(boundaries []float64) error {
+	for i := 1; i < len(boundaries); i++ {
+		if boundaries[i] <= boundaries[i-1] {
+			return fmt.Errorf("boundaries must be strictly increasing, got %v after %v", boundaries[i], boundaries[i-1])
+		}
+	}
+	return nil
+}
+
+// attributes returns the metric attributes for the given request properties.
+// Inflight requests don't have status code yet, so the attributes for them
+// are reduced.
+func (r *metricsRecorder) attributes(p HTTPReqProperties, inflight bool) []attribute.KeyValue {
+	if p.attrs != nil {
+		return p.attrs[:len(p.attrs):len(p.attrs)]
+	}
+	attrs := []attribute.KeyValue{serviceKey.String(p.Service)}
+	if r.semConvStability.emitOld() {
+		attrs = append(attrs, idKey.String(p.ID))
+		if !inflight {
+			attrs = append(attrs, methodKey.String(p.Method), codeKey.Int(p.Code))
+		}
+		if p.Scheme != "" {
+			attrs = append(attrs, schemeKey.String(p.Scheme))
+		}
+		if p.ProtocolVersion != "" {
+			attrs = append(attrs, flavorKey.String(p.ProtocolVersion))
+		}
+	}
+	if r.semConvStability.emitStable() {
+		// the route must be a template, so the ID which may be the
+		// request path is not used
+		attrs = withRoute(attrs, p.Route)
+		attrs = append(attrs, semconvstable.HTTPRequestMethodKey.String(p.Method))
+		if !inflight {
+			attrs = append(attrs, semconvstable.HTTPResponseStatusCode(p.Code))
+		}
+		if p.Scheme != "" {
+			attrs = append(attrs, semconvstable.URLScheme(p.Scheme))
+		}
+		if p.ProtocolVersion != "" {
+			attrs = append(attrs, semconvstable.NetworkProtocolVersion(p.ProtocolVersion))
+		}
+	}
+	if p.PeerService != "" {
+		attrs = append(attrs, semconvstable.PeerService(p.PeerService))
+	}
+	return attrs
+}
+
+// withRoute appends the `http.route` attribute of the given route, unless it
+// is empty or the attributes already have it.
+func withRoute(attrs []attribute.KeyValue, route string) []attribute.KeyValue {
+	if route == "" {
+		return attrs
+	}
+	for _, attr := range attrs {
+		if attr.Key == semconvstable.HTTPRouteKey {
+			return attrs
+		}
+	}
+	return append(attrs[:len(attrs):len(attrs)], semconvstable.HTTPRoute(route))
+}
+
+// statusClass returns the class of the given status code, e.g `2xx`. Status
+// code 0 means the handler never wrote the response, which is treated as
+// `200 OK` just like the span status does.
+func statusClass(code int) string {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code/100) + "xx"
 }
 
-func (r *metricsRecorder) RecordRequestDuration(ctx context.Context, p httpReqProperties, duration time.Duration) {
+func (r *metricsRecorder) RecordRequestDuration(ctx context.Context, p HTTPReqProperties, duration time.Duration) {
+	attrs := r.attributes(p, false)
+	if r.httpRequestsCounter != nil {
+		r.httpRequestsCounter.Add(ctx, 1,
+			otelmetric.WithAttributes(append(attrs, statusClassKey.String(statusClass(p.Code)))...),
+		)
+	}
+	if r.httpTimeoutsCounter != nil && p.TimedOut {
+		// keyed by the resolved route, the ID may be the request path
+		r.httpTimeoutsCounter.Add(ctx, 1, otelmetric.WithAttributes(withRoute(attrs, p.Route)...))
+	}
+	if r.exemplarRouteAttribute {
+		attrs = withRoute(attrs, p.Route)
+	}
+	if p.CacheStatus != "" {
+		attrs = append(attrs, cacheStatusKey.String(p.CacheStatus))
+	}
+	if p.RequestContentType != "" {
+		attrs = append(attrs, requestContentTypeKey.String(p.RequestContentType))
+	}
+	if p.ResponseContentType != "" {
+		attrs = append(attrs, responseContentTypeKey.String(p.ResponseContentType))
+	}
+	if r.httpRequestDurMsHistogram != nil {
+		r.httpRequestDurMsHistogram.Record(ctx,
+			float64(duration)/float64(time.Millisecond),
+			otelmetric.WithAttributes(attrs...),
+		)
+		return
+	}
 	r.httpRequestDurHistogram.Record(ctx,
 		int64(duration.Seconds()),
-		otelmetric.WithAttributes(
-			serviceKey.String(p.Service),
-			idKey.String(p.ID),
-			methodKey.String(p.Method),
-			codeKey.Int(p.Code),
-		),
+		otelmetric.WithAttributes(attrs...),
 	)
 }
 
-func (r *metricsRecorder) RecordResponseSize(ctx context.Context, p httpReqProperties, size int64) {
+func (r *metricsRecorder) RecordResponseSize(ctx context.Context, p HTTPReqProperties, size int64) {
 	r.httpResponseSizeHistogram.Record(ctx,
 		size,
-		otelmetric.WithAttributes(
-			serviceKey.String(p.Service),
-			idKey.String(p.ID),
-			methodKey.String(p.Method),
-			codeKey.Int(p.Code),
-		),
+		otelmetric.WithAttributes(r.attributes(p, false)...),
 	)
 }
 
-func (r *metricsRecorder) RecordRequestsInflight(ctx context.Context, p httpReqProperties, count int64) {
+func (r *metricsRecorder) RecordRequestsInflight(ctx context.Context, p HTTPReqProperties, count int64) {
 	r.httpRequestsInflight.Add(ctx,
 		count,
-		otelmetric.WithAttributes(
-			serviceKey.String(p.Service),
-			idKey.String(p.ID),
-		),
+		otelmetric.WithAttributes(r.attributes(p, true)...),
 	)
 }
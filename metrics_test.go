@@ -0,0 +1,996 @@
+package otelchi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type testMeasurement struct {
+	ctx   context.Context
+	name  string
+	value int64
+	attrs attribute.Set
+
+	// floatValue is the value recorded by the float64 instruments
+	floatValue float64
+}
+
+// testMeterProvider is a minimal meter provider that records every
+// measurement made by the instruments it created.
+type testMeterProvider struct {
+	embedded.MeterProvider
+
+	mu           sync.Mutex
+	measurements []testMeasurement
+	boundaries   map[string][]float64
+	meterName    string
+	meterVersion string
+}
+
+func (p *testMeterProvider) Meter(name string, opts ...otelmetric.MeterOption) otelmetric.Meter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.meterName = name
+	p.meterVersion = otelmetric.NewMeterConfig(opts...).InstrumentationVersion()
+	return &testMeter{provider: p}
+}
+
+func (p *testMeterProvider) record(m testMeasurement) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.measurements = append(p.measurements, m)
+}
+
+// Measurements returns the recorded measurements of the instrument with the
+// given name.
+func (p *testMeterProvider) Measurements(name string) []testMeasurement {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var res []testMeasurement
+	for _, m := range p.measurements {
+		if m.name == name {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+type testMeter struct {
+	noop.Meter
+	provider *testMeterProvider
+}
+
+func (m *testMeter) Int64Counter(name string, _ ...otelmetric.Int64CounterOption) (otelmetric.Int64Counter, error) {
+	return &testInstrument{provider: m.provider, name: name}, nil
+}
+
+func (m *testMeter) Int64UpDownCounter(name string, _ ...otelmetric.Int64UpDownCounterOption) (otelmetric.Int64UpDownCounter, error) {
+	return &testInstrument{provider: m.provider, name: name}, nil
+}
+
+func (m *testMeter) Int64Histogram(name string, opts ...otelmetric.Int64HistogramOption) (otelmetric.Int64Histogram, error) {
+	m.provider.mu.Lock()
+	defer m.provider.mu.Unlock()
+	if m.provider.boundaries == nil {
+		m.provider.boundaries = map[string][]float64{}
+	}
+	m.provider.boundaries[name] = otelmetric.NewInt64HistogramConfig(opts...).ExplicitBucketBoundaries()
+	return &testInstrument{provider: m.provider, name: name}, nil
+}
+
+func (m *testMeter) Float64Histogram(name string, opts ...otelmetric.Float64HistogramOption) (otelmetric.Float64Histogram, error) {
+	m.provider.mu.Lock()
+	defer m.provider.mu.Unlock()
+	if m.provider.boundaries == nil {
+		m.provider.boundaries = map[string][]float64{}
+	}
+	m.provider.boundaries[name] = otelmetric.NewFloat64HistogramConfig(opts...).ExplicitBucketBoundaries()
+	return &testFloat64Instrument{provider: m.provider, name: name}, nil
+}
+
+type testInstrument struct {
+	embedded.Int64Counter
+	embedded.Int64UpDownCounter
+	embedded.Int64Histogram
+
+	provider *testMeterProvider
+	name     string
+}
+
+func (i *testInstrument) Add(ctx context.Context, incr int64, opts ...otelmetric.AddOption) {
+	i.provider.record(testMeasurement{
+		ctx:   ctx,
+		name:  i.name,
+		value: incr,
+		attrs: otelmetric.NewAddConfig(opts).Attributes(),
+	})
+}
+
+func (i *testInstrument) Record(ctx context.Context, value int64, opts ...otelmetric.RecordOption) {
+	i.provider.record(testMeasurement{
+		ctx:   ctx,
+		name:  i.name,
+		value: value,
+		attrs: otelmetric.NewRecordConfig(opts).Attributes(),
+	})
+}
+
+type testFloat64Instrument struct {
+	embedded.Float64Histogram
+
+	provider *testMeterProvider
+	name     string
+}
+
+func (i *testFloat64Instrument) Record(ctx context.Context, value float64, opts ...otelmetric.RecordOption) {
+	i.provider.record(testMeasurement{
+		ctx:        ctx,
+		name:       i.name,
+		floatValue: value,
+		attrs:      otelmetric.NewRecordConfig(opts).Attributes(),
+	})
+}
+
+// bucketCounts returns the number of measurements falling in each bucket of
+// the given explicit boundaries, the upper bound of a bucket is inclusive
+// like in the SDK.
+func bucketCounts(boundaries []float64, measurements []testMeasurement) []int {
+	counts := make([]int, len(boundaries)+1)
+	for _, m := range measurements {
+		i := 0
+		for i < len(boundaries) && m.floatValue > boundaries[i] {
+			i++
+		}
+		counts[i]++
+	}
+	return counts
+}
+
+func TestMetricsAttributes(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithMeterProvider(provider)))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0],
+		attribute.String("service", "foobar"),
+		attribute.String("id", "/user/123"),
+		attribute.String("method", "GET"),
+		attribute.Int("code", http.StatusOK),
+	)
+
+	inflights := provider.Measurements("requests_inflight")
+	require.Len(t, inflights, 2)
+	assertMetricAttributes(t, inflights[0],
+		attribute.String("service", "foobar"),
+		attribute.String("id", "/user/123"),
+	)
+}
+
+func TestMetricsAttributesWithSemConvStability(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Mode       SemConvStability
+		Expected   []attribute.KeyValue
+		Unexpected []attribute.Key
+	}{
+		{
+			Name: "Stable",
+			Mode: SemConvStabilityHTTP,
+			Expected: []attribute.KeyValue{
+				attribute.String("service", "foobar"),
+				attribute.String("http.route", "/user/{id:[0-9]+}"),
+				attribute.String("http.request.method", "GET"),
+				attribute.Int("http.response.status_code", http.StatusOK),
+			},
+			Unexpected: []attribute.Key{"id", "method", "code"},
+		},
+		{
+			Name: "Duplicate",
+			Mode: SemConvStabilityHTTPDup,
+			Expected: []attribute.KeyValue{
+				attribute.String("service", "foobar"),
+				attribute.String("id", "/user/{id:[0-9]+}"),
+				attribute.String("method", "GET"),
+				attribute.Int("code", http.StatusOK),
+				attribute.String("http.route", "/user/{id:[0-9]+}"),
+				attribute.String("http.request.method", "GET"),
+				attribute.Int("http.response.status_code", http.StatusOK),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			provider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware(
+				"foobar",
+				WithMeterProvider(provider),
+				WithChiRoutes(router),
+				WithSemConvStability(testCase.Mode),
+			))
+			router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			durations := provider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], testCase.Expected...)
+			for _, key := range testCase.Unexpected {
+				assert.False(t, durations[0].attrs.HasValue(key), "unexpected attribute %s", key)
+			}
+		})
+	}
+}
+
+func TestMetricsStableRouteAttribute(t *testing.T) {
+	// the route is unknown before the handler is done, the request path must
+	// not be used in place of the template
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithSemConvStability(SemConvStabilityHTTP),
+		WithExemplarRouteAttribute(true),
+		WithRecordTimeouts(true),
+		WithGatewayTimeoutStatus(true),
+	))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	for _, name := range []string{"request_duration_seconds", "response_size_bytes", "requests_timeout"} {
+		measurements := provider.Measurements(name)
+		require.Len(t, measurements, 1, name)
+		assertMetricAttributes(t, measurements[0], attribute.String("http.route", "/user/{id}"))
+	}
+	for _, m := range provider.Measurements("requests_inflight") {
+		assert.False(t, m.attrs.HasValue("http.route"))
+	}
+}
+
+func TestWithRoute(t *testing.T) {
+	attrs := []attribute.KeyValue{attribute.String("service", "foobar")}
+	attrs = withRoute(attrs, "/user/{id}")
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("service", "foobar"),
+		attribute.String("http.route", "/user/{id}"),
+	}, attrs)
+	// the route is only added once
+	assert.Equal(t, attrs, withRoute(attrs, "/user/{id}"))
+	assert.Equal(t, attrs[:1], withRoute(attrs[:1], ""))
+}
+
+func TestMetricsDurationHistogramBoundaries(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Boundaries []float64
+		Expected   []float64
+	}{
+		{
+			Name:       "Valid boundaries",
+			Boundaries: []float64{0.001, 0.01, 0.1, 1},
+			Expected:   []float64{0.001, 0.01, 0.1, 1},
+		},
+		{
+			Name:       "Unsorted boundaries fallback to default",
+			Boundaries: []float64{0.1, 0.01, 1},
+		},
+		{
+			Name:       "Duplicated boundaries fallback to default",
+			Boundaries: []float64{0.1, 0.1, 1},
+		},
+		{
+			Name: "No boundaries",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			provider := &testMeterProvider{}
+			Middleware(
+				"foobar",
+				WithMeterProvider(provider),
+				WithDurationHistogramBoundaries(testCase.Boundaries),
+			)
+
+			assert.Equal(t, testCase.Expected, provider.boundaries["request_duration_seconds"])
+			assert.Nil(t, provider.boundaries["response_size_bytes"])
+		})
+	}
+}
+
+func TestMetricsDurationHistogramBuckets(t *testing.T) {
+	// sub-second requests are spread across the millisecond boundaries
+	boundaries := []float64{20, 1000}
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithDurationUnit(DurationUnitMilliseconds),
+		WithDurationHistogramBoundaries(boundaries),
+	))
+	router.HandleFunc("/fast", ok)
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fast", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+
+	durations := provider.Measurements("request_duration_milliseconds")
+	require.Len(t, durations, 2)
+	assert.Equal(t, boundaries, provider.boundaries["request_duration_milliseconds"])
+	assert.Equal(t, []int{1, 1, 0}, bucketCounts(boundaries, durations))
+}
+
+func TestMetricsNamePrefixAndDurationUnit(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithMetricNamePrefix("myapp_"),
+		WithDurationUnit(DurationUnitMilliseconds),
+	))
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r0 := httptest.NewRequest("GET", "/slow", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	assert.Len(t, provider.Measurements("request_duration_seconds"), 0)
+	durations := provider.Measurements("myapp_request_duration_milliseconds")
+	require.Len(t, durations, 1)
+	assert.GreaterOrEqual(t, durations[0].floatValue, float64(5))
+	assert.Len(t, provider.Measurements("myapp_response_size_bytes"), 1)
+	assert.Len(t, provider.Measurements("myapp_requests_inflight"), 2)
+}
+
+func TestMetricsDurationValue(t *testing.T) {
+	provider := &testMeterProvider{}
+	msProvider := &testMeterProvider{}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithMeterProvider(provider)))
+	router.HandleFunc("/slow", handler)
+	msRouter := chi.NewRouter()
+	msRouter.Use(Middleware("foobar",
+		WithMeterProvider(msProvider),
+		WithDurationUnit(DurationUnitMilliseconds),
+	))
+	msRouter.HandleFunc("/slow", handler)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	msRouter.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+
+	// the seconds are whole numbers recorded in the integer histogram
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assert.Equal(t, int64(0), durations[0].value)
+	assert.Equal(t, float64(0), durations[0].floatValue)
+
+	// the milliseconds are recorded in the float histogram
+	durations = msProvider.Measurements("request_duration_milliseconds")
+	require.Len(t, durations, 1)
+	assert.GreaterOrEqual(t, durations[0].floatValue, float64(5))
+	assert.Less(t, durations[0].floatValue, float64(1000))
+}
+
+func TestMetricsExemplarRouteAttribute(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Options       []Option
+		ExpectedRoute string
+	}{
+		{
+			Name:          "Route resolved after the handler",
+			Options:       []Option{WithExemplarRouteAttribute(true)},
+			ExpectedRoute: "/user/{id:[0-9]+}",
+		},
+		{
+			Name:    "Disabled",
+			Options: []Option{WithExemplarRouteAttribute(false)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			provider := &testMeterProvider{}
+			sr := tracetest.NewSpanRecorder()
+			tracerProvider := sdktrace.NewTracerProvider()
+			tracerProvider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithMeterProvider(provider),
+				WithTracerProvider(tracerProvider),
+			)...))
+			router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			durations := provider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			if testCase.ExpectedRoute == "" {
+				assert.False(t, durations[0].attrs.HasValue("http.route"))
+			} else {
+				assertMetricAttributes(t, durations[0],
+					attribute.String("http.route", testCase.ExpectedRoute),
+				)
+			}
+
+			// the measurement is made within the server span, so the
+			// exemplar points to its trace
+			require.Len(t, sr.Ended(), 1)
+			spanCtx := trace.SpanContextFromContext(durations[0].ctx)
+			assert.Equal(t, sr.Ended()[0].SpanContext().TraceID(), spanCtx.TraceID())
+			assert.Equal(t, sr.Ended()[0].SpanContext().SpanID(), spanCtx.SpanID())
+
+			// only the duration carries the route
+			sizes := provider.Measurements("response_size_bytes")
+			require.Len(t, sizes, 1)
+			assert.False(t, sizes[0].attrs.HasValue("http.route"))
+		})
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	provider := &testMeterProvider{}
+	sr := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider()
+	tracerProvider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithTracerProvider(tracerProvider),
+		WithMetricsDisabled(true),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// no instrument is created & nothing is recorded
+	assert.Nil(t, provider.boundaries)
+	assert.Empty(t, provider.measurements)
+
+	require.Len(t, sr.Ended(), 1)
+	assert.Equal(t, "/user/{id:[0-9]+}", sr.Ended()[0].Name())
+}
+
+type testMetricsRecorder struct {
+	mu        sync.Mutex
+	inflight  []int64
+	durations []HTTPReqProperties
+	sizes     []int64
+}
+
+func (r *testMetricsRecorder) RecordRequestsInflight(_ context.Context, _ HTTPReqProperties, count int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inflight = append(r.inflight, count)
+}
+
+func (r *testMetricsRecorder) RecordRequestDuration(_ context.Context, p HTTPReqProperties, _ time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.durations = append(r.durations, p)
+}
+
+func (r *testMetricsRecorder) RecordResponseSize(_ context.Context, _ HTTPReqProperties, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sizes = append(r.sizes, size)
+}
+
+func TestMetricsWithCustomRecorder(t *testing.T) {
+	provider := &testMeterProvider{}
+	recorder := &testMetricsRecorder{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithChiRoutes(router),
+		WithMetricsRecorder(recorder),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("not found"))
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	assert.Equal(t, []int64{1, -1}, recorder.inflight)
+	assert.Equal(t, []int64{9}, recorder.sizes)
+	require.Len(t, recorder.durations, 1)
+	assert.Equal(t, HTTPReqProperties{
+		Service: "foobar",
+		ID:      "/user/{id:[0-9]+}",
+		Method:  "GET",
+		Code:    http.StatusNotFound,
+		Route:   "/user/{id:[0-9]+}",
+	}, recorder.durations[0])
+
+	// the default recorder is not created
+	assert.Nil(t, provider.boundaries)
+	assert.Empty(t, provider.measurements)
+}
+
+func TestValidateHistogramBoundaries(t *testing.T) {
+	assert.NoError(t, validateHistogramBoundaries(nil))
+	assert.NoError(t, validateHistogramBoundaries([]float64{1}))
+	assert.NoError(t, validateHistogramBoundaries([]float64{0, 0.5, 1}))
+	assert.EqualError(t,
+		validateHistogramBoundaries([]float64{0, 1, 0.5}),
+		"boundaries must be strictly increasing, got 0.5 after 1",
+	)
+}
+
+func assertMetricAttributes(t *testing.T, m testMeasurement, attrs ...attribute.KeyValue) {
+	for _, want := range attrs {
+		got, ok := m.attrs.Value(want.Key)
+		if !assert.True(t, ok, "missing attribute %s", want.Key) {
+			continue
+		}
+		assert.Equal(t, want.Value, got)
+	}
+}
+
+func TestMetricsRequestCount(t *testing.T) {
+	testCases := []struct {
+		Name                string
+		Options             []Option
+		ExpectedStatusClass []string
+	}{
+		{
+			Name:                "Enabled",
+			Options:             []Option{WithMeasureRequestCount(true)},
+			ExpectedStatusClass: []string{"2xx", "4xx", "5xx", "2xx"},
+		},
+		{
+			Name: "Disabled by default",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			provider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithMeterProvider(provider))...))
+			router.HandleFunc("/status/{code}", func(w http.ResponseWriter, r *http.Request) {
+				switch chi.URLParam(r, "code") {
+				case "404":
+					w.WriteHeader(http.StatusNotFound)
+				case "500":
+					w.WriteHeader(http.StatusInternalServerError)
+				case "200":
+					w.WriteHeader(http.StatusOK)
+				}
+				// otherwise nothing is written
+			})
+
+			for _, code := range []string{"200", "404", "500", "none"} {
+				r0 := httptest.NewRequest("GET", "/status/"+code, nil)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r0)
+			}
+
+			counts := provider.Measurements("requests_total")
+			require.Len(t, counts, len(testCase.ExpectedStatusClass))
+			for i, statusClass := range testCase.ExpectedStatusClass {
+				assert.Equal(t, int64(1), counts[i].value)
+				assertMetricAttributes(t, counts[i],
+					attribute.String("service", "foobar"),
+					attribute.String("status_class", statusClass),
+				)
+			}
+		})
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, "2xx", statusClass(0))
+	assert.Equal(t, "1xx", statusClass(http.StatusSwitchingProtocols))
+	assert.Equal(t, "3xx", statusClass(http.StatusFound))
+	assert.Equal(t, "4xx", statusClass(http.StatusTooManyRequests))
+	assert.Equal(t, "5xx", statusClass(http.StatusBadGateway))
+}
+
+func TestMetricsCacheStatusAttribute(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Options  []Option
+		Expected bool
+	}{
+		{
+			Name:     "Enabled",
+			Options:  []Option{WithCacheStatusMetricAttribute(true)},
+			Expected: true,
+		},
+		{
+			Name: "Disabled by default",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			provider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithMeterProvider(provider),
+				WithCacheStatusHeader("X-Cache"),
+			)...))
+			router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Cache", "MISS")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			durations := provider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			if testCase.Expected {
+				assertMetricAttributes(t, durations[0], attribute.String("http.cache.status", "miss"))
+			} else {
+				assert.False(t, durations[0].attrs.HasValue("http.cache.status"))
+			}
+
+			// only the duration carries the cache status
+			sizes := provider.Measurements("response_size_bytes")
+			require.Len(t, sizes, 1)
+			assert.False(t, sizes[0].attrs.HasValue("http.cache.status"))
+		})
+	}
+}
+
+func TestMetricsContentTypeAttributes(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithRequestContentTypeAttribute(true),
+		WithResponseContentTypeAttribute(true),
+		WithContentTypeMetricAttributes(true),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r0 := httptest.NewRequest("POST", "/user/123", nil)
+	r0.Header.Set("Content-Type", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0],
+		attribute.String("http.request.content_type", "application/x-protobuf"),
+		attribute.String("http.response.content_type", "application/json"),
+	)
+
+	// the inflight requests don't carry the content types
+	inflight := provider.Measurements("requests_inflight")
+	require.Len(t, inflight, 2)
+	assert.False(t, inflight[0].attrs.HasValue("http.request.content_type"))
+}
+
+func TestMetricsUnmatchedRoute(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithMeterProvider(provider)))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	for _, path := range []string{"/user/123", "/wp-admin/setup.php", "/.env"} {
+		r0 := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r0)
+	}
+
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 3)
+	assertMetricAttributes(t, durations[0], attribute.String("id", "/user/123"))
+	assertMetricAttributes(t, durations[1], attribute.String("id", "unmatched"))
+	assertMetricAttributes(t, durations[2], attribute.String("id", "unmatched"))
+}
+
+func TestMetricsTrimTrailingWildcard(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithMeterProvider(provider),
+		WithChiRoutes(router),
+		WithTrimTrailingWildcard(true),
+		WithSemConvStability(SemConvStabilityHTTPDup),
+	))
+	router.HandleFunc("/static/*", ok)
+
+	r0 := httptest.NewRequest("GET", "/static/css/main.css", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0],
+		attribute.String("id", "/static"),
+		attribute.String("http.route", "/static"),
+	)
+}
+
+func TestMetricsResponseSizeMultipleWrites(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithMeterProvider(provider)))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+		w.Write([]byte(", "))
+		w.Write([]byte("world"))
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	sizes := provider.Measurements("response_size_bytes")
+	require.Len(t, sizes, 1)
+	assert.Equal(t, int64(len("hello, world")), sizes[0].value)
+}
+
+func TestRecordingResponseWriterMultipleWrites(t *testing.T) {
+	testCases := []struct {
+		Name string
+		RRW  func(w http.ResponseWriter) *recordingResponseWriter
+	}{
+		{
+			Name: "Snoop writer",
+			RRW: func(w http.ResponseWriter) *recordingResponseWriter {
+				return getRRW(w, nil, false, nil)
+			},
+		},
+		{
+			Name: "Status writer",
+			RRW: func(w http.ResponseWriter) *recordingResponseWriter {
+				return getStatusRRW(w, 1, false)
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			rrw := testCase.RRW(httptest.NewRecorder())
+			rrw.writer.WriteHeader(http.StatusCreated)
+			_, _ = rrw.writer.Write([]byte("hello"))
+			_, _ = rrw.writer.Write([]byte(", "))
+			_, _ = rrw.writer.Write([]byte("world"))
+
+			// the bytes are summed while the status is kept from the first write
+			assert.Equal(t, int64(len("hello, world")), rrw.writtenBytes)
+			assert.Equal(t, http.StatusCreated, rrw.status)
+		})
+	}
+}
+
+func TestMetricsSpanContext(t *testing.T) {
+	provider := &testMeterProvider{}
+	sr := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	tracerProvider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithMeterProvider(provider),
+		WithTracerProvider(tracerProvider),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// every measurement is made within the sampled server span, so the SDK
+	// can attach exemplars pointing to it
+	require.Len(t, sr.Ended(), 1)
+	want := sr.Ended()[0].SpanContext()
+	require.True(t, want.IsSampled())
+	for _, name := range []string{"request_duration_seconds", "response_size_bytes", "requests_inflight"} {
+		measurements := provider.Measurements(name)
+		require.NotEmpty(t, measurements, name)
+		for _, m := range measurements {
+			assert.True(t, want.Equal(trace.SpanContextFromContext(m.ctx)), name)
+		}
+	}
+}
+
+func TestMetricsCustomAttributes(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithMeterProvider(provider),
+		WithMetricAttributes(func(p HTTPReqProperties, r *http.Request) []attribute.KeyValue {
+			attrs := []attribute.KeyValue{
+				attribute.String("method", p.Method),
+				attribute.String("tenant", r.Header.Get("X-Tenant")),
+			}
+			// the route of the search endpoint is dropped
+			if p.Route != "/search/*" {
+				attrs = append(attrs, attribute.String("id", p.ID))
+			}
+			return attrs
+		}),
+	))
+	router.HandleFunc("/user/{id}", ok)
+	router.HandleFunc("/search/*", ok)
+
+	for _, path := range []string{"/user/123", "/search/foo/bar"} {
+		r0 := httptest.NewRequest("GET", path, nil)
+		r0.Header.Set("X-Tenant", "acme")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r0)
+	}
+
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 2)
+	assert.Equal(t, attribute.NewSet(
+		attribute.String("method", "GET"),
+		attribute.String("tenant", "acme"),
+		attribute.String("id", "/user/123"),
+	), durations[0].attrs)
+	assert.Equal(t, attribute.NewSet(
+		attribute.String("method", "GET"),
+		attribute.String("tenant", "acme"),
+	), durations[1].attrs)
+
+	sizes := provider.Measurements("response_size_bytes")
+	require.Len(t, sizes, 2)
+	assert.Equal(t, durations[0].attrs, sizes[0].attrs)
+
+	inflight := provider.Measurements("requests_inflight")
+	require.Len(t, inflight, 4)
+	assertMetricAttributes(t, inflight[0], attribute.String("tenant", "acme"))
+	assert.False(t, inflight[0].attrs.HasValue("service"))
+}
+
+func TestMetricsUnmatchedRouteLabel(t *testing.T) {
+	provider := &testMeterProvider{}
+	sr := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider()
+	tracerProvider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithMeterProvider(provider),
+		WithTracerProvider(tracerProvider),
+		WithUnmatchedRouteLabel("__unmatched__"),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/wp-admin/setup.php", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	durations := provider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.String("id", "__unmatched__"))
+
+	// the span keeps the unmatched route name
+	require.Len(t, sr.Ended(), 1)
+	assert.Equal(t, "unmatched", sr.Ended()[0].Name())
+}
+
+func TestMetricsUnmatchedRouteLabelWithChiRoutes(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithMeterProvider(provider),
+		WithChiRoutes(router),
+		WithUnmatchedRouteLabel("__unmatched__"),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	for _, path := range []string{"/user/123", "/random1", "/random2"} {
+		r0 := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r0)
+	}
+
+	// the nonexistent paths collapse to one series in both instruments
+	for _, name := range []string{"request_duration_seconds", "requests_inflight"} {
+		ids := map[string]int{}
+		for _, m := range provider.Measurements(name) {
+			id, _ := m.attrs.Value("id")
+			ids[id.AsString()]++
+		}
+		perRequest := len(provider.Measurements(name)) / 3
+		assert.Equal(t, map[string]int{
+			"/user/{id:[0-9]+}": perRequest,
+			"__unmatched__":     2 * perRequest,
+		}, ids, name)
+	}
+}
+
+func TestMetricsRouteMetricID(t *testing.T) {
+	provider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithMeterProvider(provider),
+		WithRouteMetricID(true),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	for _, path := range []string{"/user/123", "/user/456", "/wp-admin/setup.php", "/.env", "/random/path"} {
+		r0 := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r0)
+	}
+
+	// the nonexistent paths collapse to one series
+	ids := map[string]int{}
+	for _, m := range provider.Measurements("request_duration_seconds") {
+		id, _ := m.attrs.Value("id")
+		ids[id.AsString()]++
+	}
+	assert.Equal(t, map[string]int{"/user/{id:[0-9]+}": 2, "unmatched": 3}, ids)
+
+	for _, m := range provider.Measurements("requests_inflight") {
+		assertMetricAttributes(t, m, attribute.String("id", ""))
+	}
+}
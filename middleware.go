@@ -1,29 +1,95 @@
 package otelchi
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/felixge/httpsnoop"
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"go.opentelemetry.io/contrib"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	otelmetric "go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 const (
 	tracerName = "github.com/riandyrn/otelchi"
 
+	urlParamAttributePrefix = "http.route.param."
+
 	traceResponseHeaderKey = "X-Trace-ID"
+
+	// statusClientClosedRequest is the nonstandard status popularized by
+	// nginx for the requests closed by the client
+	statusClientClosedRequest = 499
+
+	// unmatchedRouteName is used in place of the route pattern when no route
+	// matches the request
+	unmatchedRouteName = "unmatched"
+
+	// maxPeerServiceLength is the max length of the recorded peer service,
+	// since the value comes from the callers
+	maxPeerServiceLength = 64
+)
+
+var (
+	httpRequestOriginKey = attribute.Key("http.request.origin")
+	sessionIDHashKey     = attribute.Key("session.id_hash")
+
+	corsPreflightKey      = attribute.Key("http.cors.preflight")
+	corsActualKey         = attribute.Key("http.cors.actual")
+	corsRequestMethodKey  = attribute.Key("http.cors.request_method")
+	corsRequestHeadersKey = attribute.Key("http.cors.request_headers")
+
+	responseMaxWriteBytesKey = attribute.Key("http.response.max_write_bytes")
+
+	requestCookieCountKey = attribute.Key("http.request.cookie_count")
+
+	deploymentSlotKey = attribute.Key("deployment.slot")
+
+	firstWriteElapsedKey = attribute.Key("http.first_write.elapsed_ms")
+
+	requestIDKey = attribute.Key("http.request_id")
+
+	cacheStatusKey = attribute.Key("http.cache.status")
+
+	connectionHijackedKey = attribute.Key("http.connection.hijacked")
+
+	clientClosedRequestKey = attribute.Key("http.client_closed_request")
+
+	requestTimeoutKey = attribute.Key("http.request.timeout_ms")
+
+	responseWriteErrorKey = attribute.Key("http.response.write_error")
+
+	routeConcurrencyCurrentKey = attribute.Key("http.route.concurrency.current")
+	routeConcurrencyLimitKey   = attribute.Key("http.route.concurrency.limit")
 )
 
 // Middleware sets up a handler to start tracing the incoming
 // requests. The serverName parameter should describe the name of the
 // (virtual) server handling the request.
+//
+// The serverName is only recorded in the `http.server_name` span attribute &
+// the `service` metric attribute, it doesn't set the `service.name` resource
+// attribute which is configured on the tracer & meter providers. Use
+// WithServerAddressAttributes for the `server.address` & `server.port`
+// attributes derived from the Host header of the request.
 func Middleware(serverName string, opts ...Option) func(next http.Handler) http.Handler {
 	cfg := config{}
 	for _, opt := range opts {
@@ -33,23 +99,68 @@ func Middleware(serverName string, opts ...Option) func(next http.Handler) http.
 	if cfg.TracerProvider == nil {
 		cfg.TracerProvider = otel.GetTracerProvider()
 	}
-	tracer := cfg.TracerProvider.Tracer(
-		tracerName,
-		oteltrace.WithInstrumentationVersion(contrib.Version()),
+	if cfg.InstrumentationName == "" {
+		cfg.InstrumentationName = tracerName
+	}
+	if cfg.InstrumentationVersion == "" {
+		cfg.InstrumentationVersion = contrib.Version()
+	}
+	tracer := newTracer(cfg.TracerProvider, cfg.InstrumentationName, cfg.InstrumentationVersion)
+
+	// the meter provider is not touched at all when metrics are disabled or
+	// when a custom recorder is used
+	var (
+		meter    otelmetric.Meter
+		recorder MetricsRecorder
 	)
+	if !cfg.MetricsDisabled {
+		recorder = cfg.MetricsRecorder
+	}
+	if !cfg.MetricsDisabled && recorder == nil {
+		if cfg.MeterProvider == nil {
+			cfg.MeterProvider = otel.GetMeterProvider()
+		}
+		meter = cfg.MeterProvider.Meter(
+			cfg.InstrumentationName,
+			otelmetric.WithInstrumentationVersion(cfg.InstrumentationVersion),
+		)
+		recorder = newMetricsRecorder(meter, cfg)
+	}
+	trustedProxies := newTrustedProxies(cfg.TrustedProxies)
 
-	if cfg.MeterProvider == nil {
-		cfg.MeterProvider = otel.GetMeterProvider()
+	// the cache status & content type headers are captured along with the response headers,
+	// so its value is the one that has been sent to the client
+	responseHeaderAttrs := newHeaderAttributes(responseHeaderAttributePrefix, cfg.ResponseHeaders)
+	snapshotHeaderAttrs := responseHeaderAttrs
+	if cfg.CacheStatusHeader != "" {
+		snapshotHeaderAttrs = append(
+			snapshotHeaderAttrs[:len(snapshotHeaderAttrs):len(snapshotHeaderAttrs)],
+			headerAttribute{name: http.CanonicalHeaderKey(cfg.CacheStatusHeader), key: cacheStatusKey},
+		)
+	}
+	if cfg.ResponseContentTypeAttribute {
+		snapshotHeaderAttrs = append(
+			snapshotHeaderAttrs[:len(snapshotHeaderAttrs):len(snapshotHeaderAttrs)],
+			headerAttribute{name: "Content-Type", key: responseContentTypeKey},
+		)
 	}
-	meter := cfg.MeterProvider.Meter(
-		tracerName,
-		otelmetric.WithInstrumentationVersion(contrib.Version()),
-	)
-	recorder := newMetricsRecorder(meter)
+
+	// the lighter response writer is enough when neither the size of the
+	// writes nor the response headers are needed
+	statusOnlyWriter := cfg.DisableMeasureSize && !cfg.PeakWriteSizeAttribute && len(snapshotHeaderAttrs) == 0
 
 	if cfg.Propagators == nil {
 		cfg.Propagators = otel.GetTextMapPropagator()
 	}
+	if cfg.UnmatchedRouteName == "" {
+		cfg.UnmatchedRouteName = unmatchedRouteName
+	}
+	// the in-flight requests only follow the label when it is set
+	// explicitly, otherwise their `id` is the request path as before
+	unmatchedLabelSet := cfg.UnmatchedRouteLabel != ""
+	if !unmatchedLabelSet {
+		cfg.UnmatchedRouteLabel = cfg.UnmatchedRouteName
+	}
 	if cfg.TraceResponseHeaderKey == "" {
 		cfg.TraceResponseHeaderKey = traceResponseHeaderKey
 	}
@@ -67,6 +178,93 @@ func Middleware(serverName string, opts ...Option) func(next http.Handler) http.
 			disableMeasureInflight: cfg.DisableMeasureInflight,
 			disableMeasureSize:     cfg.DisableMeasureSize,
 			traceResponseHeaderKey: cfg.TraceResponseHeaderKey,
+			recordOrigin:           cfg.RecordOrigin,
+			sessionCookieName:      cfg.SessionCookieName,
+			requestHeaderAttrs:     newHeaderAttributes(requestHeaderAttributePrefix, cfg.RequestHeaders),
+			responseHeaderAttrs:    responseHeaderAttrs,
+			semConvStability:       cfg.SemConvStability,
+			disableRecordPanics:    cfg.DisableRecordPanics,
+			handlerError:           newHandlerError(cfg.ErrorFromContext, cfg.HandlerErrorKey),
+			headerRedactor:         newHeaderRedactor(!cfg.DisableDefaultHeaderRedaction, cfg.HeaderRedaction),
+			concurrencyLimit:       cfg.ConcurrencyLimit,
+			urlParamAttrs:          newURLParamAttributes(cfg.URLParams),
+			queryRedactor:          newQueryRedactor(cfg.QueryParamRedaction, cfg.DropQueryString, cfg.QueryRedactor),
+			corsAttributes:         cfg.CORSAttributes,
+			peakWriteSizeAttribute: cfg.PeakWriteSizeAttribute,
+			disableReqContentLen:   cfg.DisableRequestContentLength,
+			forceSampleRoutes:      newForceSampleRoutes(cfg.ForceSampleRoutes),
+			respContentLen:         cfg.ResponseContentLength,
+			cookieCountAttribute:   cfg.CookieCountAttribute,
+			clientIPResolver:       newClientIPResolver(cfg.ClientIPHeaders, trustedProxies),
+			trustedProxies:         trustedProxies,
+			forwardedHeader:        cfg.ForwardedHeader,
+			tracerProviderSelector: cfg.TracerProviderSelector,
+			schemeFromProxyHeaders: cfg.SchemeFromProxyHeaders,
+			routeFilter:            cfg.RouteFilter,
+			skipMethods:            newSkipMethods(cfg.SkipMethods),
+			deploymentSlot:         cfg.DeploymentSlot,
+			protocolVersionMetric:  cfg.MetricProtocolVersion,
+			disableRRWPool:         cfg.DisableResponseWriterPool,
+			tlsAttributes:          cfg.TLSAttributes,
+			clientIPFunc:           cfg.ClientIPResolver,
+			tracingDisabled:        cfg.TracingDisabled,
+			endUserExtractor:       cfg.EndUserExtractor,
+			baggageAttributes:      cfg.BaggageAttributes,
+			baggageKeys:            cfg.BaggageKeys,
+			requestIDAttribute:     cfg.RequestIDAttribute,
+			requestIDHeader:        cfg.RequestIDHeader,
+			serverAddress:          cfg.ServerAddressAttributes,
+			snapshotHeaderAttrs:    snapshotHeaderAttrs,
+			cacheStatusHeader:      cfg.CacheStatusHeader,
+			cacheStatusMetric:      cfg.CacheStatusMetricAttribute,
+			errorStatusCodes:       newErrorStatusCodes(cfg.ErrorStatusCodes),
+			injectResponseCtx:      cfg.InjectResponseContext,
+			rateLimitAttributes:    cfg.RateLimitAttributes,
+			rateLimitAlways:        cfg.RateLimitAttributesAlways,
+			reqContentType:         cfg.RequestContentTypeAttribute,
+			respContentType:        cfg.ResponseContentTypeAttribute,
+			contentTypeMetric:      cfg.ContentTypeMetricAttributes,
+			attrValueLimit:         cfg.AttributeValueLimit,
+			sampleDecision:         cfg.SampleDecision,
+			httpTarget:             cfg.HTTPTarget,
+			publicEndpoint:         cfg.PublicEndpoint,
+			publicEndpointFn:       cfg.PublicEndpointFn,
+			spanNameFormatter:      cfg.SpanNameFormatter,
+			unmatchedRouteName:     cfg.UnmatchedRouteName,
+			serverNameResolver:     cfg.ServerNameResolver,
+			queryStringAttribute:   cfg.QueryStringAttribute,
+			trimTrailingWildcard:   cfg.TrimTrailingWildcard,
+			allURLParams:           cfg.RouteParamsAttributes,
+			serverNameInSpanName:   cfg.ServerNameInSpanName,
+			trailerStatusKey:       cfg.TrailerStatusKey,
+			trailerStatusFn:        cfg.TrailerStatusFn,
+			trimSlash:              cfg.TrimTrailingSlash,
+			routeTransformer:       cfg.RoutePatternTransformer,
+			spanOptions:            cfg.SpanOptions,
+			preflightSpanName:      cfg.CORSPreflightSpanName,
+			knownMethods:           newKnownMethods(cfg.NormalizeUnknownMethods, cfg.KnownMethods),
+			statusOnlyWriter:       statusOnlyWriter,
+			headAsGet:              cfg.HeadAsGet,
+			disableRepanic:         cfg.DisableRepanic,
+			strictStatusMapping:    cfg.StrictStatusMapping,
+			spanStatusMapper:       cfg.SpanStatusMapper,
+			clientDisconnects:      cfg.ClientDisconnects,
+			clientClosedStatus:     cfg.ClientClosedStatus,
+			recordTimeouts:         cfg.RecordTimeouts,
+			gatewayTimeoutStatus:   cfg.GatewayTimeoutStatus,
+			metricAttributes:       cfg.MetricAttributes,
+			unmatchedRouteLabel:    cfg.UnmatchedRouteLabel,
+			unmatchedLabelSet:      unmatchedLabelSet,
+			routeMetricID:          cfg.RouteMetricID,
+			requestLogger:          cfg.RequestLogger,
+			skipPreflightDuration:  cfg.SkipCORSPreflightDuration,
+			instrumentationName:    cfg.InstrumentationName,
+			instrumentationVersion: cfg.InstrumentationVersion,
+			disableUserAgent:       cfg.DisableUserAgent,
+			userAgentMaxLength:     cfg.UserAgentMaxLength,
+			ttfbEvent:              cfg.TimeToFirstByteEvent,
+			peerServiceHeader:      cfg.PeerServiceHeader,
+			peerServiceMetric:      cfg.PeerServiceMetricAttribute,
 		}
 	}
 }
@@ -75,22 +273,184 @@ type otelware struct {
 	serverName             string
 	tracer                 oteltrace.Tracer
 	meter                  otelmetric.Meter
-	recorder               *metricsRecorder
+	recorder               MetricsRecorder
 	propagators            propagation.TextMapPropagator
 	handler                http.Handler
 	chiRoutes              chi.Routes
 	reqMethodInSpanName    bool
 	filter                 func(r *http.Request) bool
+	routeFilter            func(routePattern string, r *http.Request) bool
+	skipMethods            map[string]bool
+	deploymentSlot         string
+	protocolVersionMetric  bool
+	disableRRWPool         bool
+	tlsAttributes          bool
+	clientIPFunc           func(r *http.Request) string
+	tracingDisabled        bool
+	endUserExtractor       func(r *http.Request) (id string, role string, ok bool)
+	baggageAttributes      bool
+	baggageKeys            []string
+	requestIDAttribute     bool
+	requestIDHeader        string
+	serverAddress          bool
+	snapshotHeaderAttrs    []headerAttribute
+	cacheStatusHeader      string
+	cacheStatusMetric      bool
+	errorStatusCodes       map[int]bool
+	injectResponseCtx      bool
+	rateLimitAttributes    bool
+	rateLimitAlways        bool
+	reqContentType         bool
+	respContentType        bool
+	contentTypeMetric      bool
+	attrValueLimit         int
+	sampleDecision         func(r *http.Request) bool
+	httpTarget             bool
+	publicEndpoint         bool
+	publicEndpointFn       func(r *http.Request) bool
+	spanNameFormatter      func(method, routePattern string, r *http.Request) string
+	unmatchedRouteName     string
+	serverNameResolver     func(r *http.Request) string
+	queryStringAttribute   bool
+	trimTrailingWildcard   bool
+	allURLParams           bool
+	serverNameInSpanName   bool
+	trailerStatusKey       string
+	trailerStatusFn        func(value string) (codes.Code, string)
+	trimSlash              bool
+	routeTransformer       func(pattern string) string
+	spanOptions            []oteltrace.SpanStartOption
+	preflightSpanName      string
+	skipPreflightDuration  bool
+	knownMethods           map[string]bool
+	statusOnlyWriter       bool
+	headAsGet              bool
+	disableRepanic         bool
+	strictStatusMapping    bool
+	spanStatusMapper       func(status int) (codes.Code, string)
+	clientDisconnects      bool
+	clientClosedStatus     bool
+	recordTimeouts         bool
+	gatewayTimeoutStatus   bool
+	metricAttributes       func(p HTTPReqProperties, r *http.Request) []attribute.KeyValue
+	unmatchedRouteLabel    string
+	unmatchedLabelSet      bool
+	routeMetricID          bool
+	requestLogger          func(info RequestInfo)
+	instrumentationName    string
+	instrumentationVersion string
+	disableUserAgent       bool
+	userAgentMaxLength     int
+	ttfbEvent              bool
+	peerServiceHeader      string
+	peerServiceMetric      bool
 	disableMeasureInflight bool
 	disableMeasureSize     bool
 	traceResponseHeaderKey string
+	recordOrigin           bool
+	sessionCookieName      string
+	requestHeaderAttrs     []headerAttribute
+	responseHeaderAttrs    []headerAttribute
+	semConvStability       SemConvStability
+	disableRecordPanics    bool
+	handlerError           func(ctx context.Context) error
+	headerRedactor         headerRedactor
+	concurrencyLimit       func(routePattern string) (current, limit int)
+	urlParamAttrs          []urlParamAttribute
+	queryRedactor          *queryRedactor
+	corsAttributes         bool
+	peakWriteSizeAttribute bool
+	disableReqContentLen   bool
+	forceSampleRoutes      map[string]bool
+	respContentLen         bool
+	cookieCountAttribute   bool
+	clientIPResolver       *clientIPResolver
+	trustedProxies         []*net.IPNet
+	forwardedHeader        bool
+	tracerProviderSelector func(r *http.Request) oteltrace.TracerProvider
+	schemeFromProxyHeaders bool
+
+	// tracers caches the tracers of the providers returned by
+	// tracerProviderSelector
+	tracers sync.Map
+}
+
+// clientAddressAttributes returns the client address span attributes for the
+// used semantic conventions.
+func (ow *otelware) clientAddressAttributes(clientIP string) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if ow.semConvStability.emitOld() {
+		attrs = append(attrs, semconv.HTTPClientIPKey.String(clientIP))
+	}
+	if ow.semConvStability.emitStable() {
+		attrs = append(attrs, semconvstable.ClientAddress(clientIP))
+	}
+	return attrs
+}
+
+func newSkipMethods(methods []string) map[string]bool {
+	if len(methods) == 0 {
+		return nil
+	}
+	res := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		res[strings.ToUpper(method)] = true
+	}
+	return res
+}
+
+func newTracer(provider oteltrace.TracerProvider, name, version string) oteltrace.Tracer {
+	return provider.Tracer(
+		name,
+		oteltrace.WithInstrumentationVersion(version),
+	)
+}
+
+// tracerFor returns the tracer used for the given request, it is the tracer of
+// the provider selected for the request if any, otherwise it is the tracer of
+// the configured provider.
+func (ow *otelware) tracerFor(r *http.Request) oteltrace.Tracer {
+	if ow.tracerProviderSelector == nil {
+		return ow.tracer
+	}
+	provider := ow.tracerProviderSelector(r)
+	if provider == nil {
+		return ow.tracer
+	}
+	if tracer, ok := ow.tracers.Load(provider); ok {
+		return tracer.(oteltrace.Tracer)
+	}
+	tracer, _ := ow.tracers.LoadOrStore(provider, newTracer(provider, ow.instrumentationName, ow.instrumentationVersion))
+	return tracer.(oteltrace.Tracer)
 }
 
 type recordingResponseWriter struct {
 	writer       http.ResponseWriter
 	written      bool
 	writtenBytes int64
+	maxWrite     int
 	status       int
+
+	// header is the snapshot of the captured response headers taken
+	// when the response is written
+	header http.Header
+
+	// routeTag is the route set by WithRouteTag
+	routeTag string
+
+	// spanName is the span name set by SetSpanName
+	spanName string
+
+	// hijacked is set when the handler took over the connection (e.g
+	// WebSocket), the response is then written outside of the writer
+	hijacked bool
+
+	// writeErr is the first error returned when writing the response (e.g
+	// broken pipe)
+	writeErr error
+
+	// next is the writer wrapped by the status writer, see getStatusRRW
+	next http.ResponseWriter
 }
 
 var rrwPool = &sync.Pool{
@@ -99,20 +459,34 @@ var rrwPool = &sync.Pool{
 	},
 }
 
-func getRRW(writer http.ResponseWriter) *recordingResponseWriter {
-	rrw := rrwPool.Get().(*recordingResponseWriter)
-	rrw.written = false
-	rrw.writtenBytes = 0
-	rrw.status = 0
+// getRRW returns a recording response writer wrapping the given writer, the
+// optional onFirstWrite is called when the response is written for the first
+// time.
+func getRRW(writer http.ResponseWriter, headerAttrs []headerAttribute, usePool bool, onFirstWrite func()) *recordingResponseWriter {
+	rrw := newRRW(usePool)
+	// firstWrite is called once the response is written for the first time
+	firstWrite := func() {
+		if len(headerAttrs) > 0 {
+			rrw.header = snapshotHeader(headerAttrs, writer.Header())
+		}
+		if onFirstWrite != nil {
+			onFirstWrite()
+		}
+	}
 	rrw.writer = httpsnoop.Wrap(writer, httpsnoop.Hooks{
 		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
 			return func(b []byte) (int, error) {
+				if len(b) > rrw.maxWrite {
+					rrw.maxWrite = len(b)
+				}
 				if !rrw.written {
 					rrw.written = true
-					rrw.writtenBytes += int64(len(b))
 					rrw.status = http.StatusOK
+					firstWrite()
 				}
-				return next(b)
+				n, err := next(b)
+				rrw.recordWrite(int64(n), err)
+				return n, err
 			}
 		},
 		WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
@@ -120,16 +494,84 @@ func getRRW(writer http.ResponseWriter) *recordingResponseWriter {
 				if !rrw.written {
 					rrw.written = true
 					rrw.status = statusCode
+					firstWrite()
 				}
 				next(statusCode)
 			}
 		},
+		// flushing & ReadFrom (used by io.Copy) bypass Write, yet they send
+		// the implicit 200 status just like Write does
+		Flush: func(next httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+			return func() {
+				if !rrw.written {
+					rrw.written = true
+					rrw.status = http.StatusOK
+					firstWrite()
+				}
+				next()
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				if !rrw.written {
+					rrw.written = true
+					rrw.status = http.StatusOK
+					firstWrite()
+				}
+				n, err := next(src)
+				rrw.recordWrite(n, err)
+				return n, err
+			}
+		},
+		Hijack: func(next httpsnoop.HijackFunc) httpsnoop.HijackFunc {
+			return func() (net.Conn, *bufio.ReadWriter, error) {
+				conn, rw, err := next()
+				if err == nil {
+					rrw.hijacked = true
+				}
+				return conn, rw, err
+			}
+		},
 	})
 	return rrw
 }
 
+// getStatusRRW returns a recording response writer which only records the
+// status & the size of the response. It wraps the given writer with the
+// statusWriter which allocates less than httpsnoop, but it neither tracks
+// the size of the writes nor notifies the first write.
+func getStatusRRW(writer http.ResponseWriter, protoMajor int, usePool bool) *recordingResponseWriter {
+	rrw := newRRW(usePool)
+	rrw.next = writer
+	rrw.writer = newStatusWriter(rrw, protoMajor)
+	return rrw
+}
+
+func newRRW(usePool bool) *recordingResponseWriter {
+	var rrw *recordingResponseWriter
+	if usePool {
+		rrw = rrwPool.Get().(*recordingResponseWriter)
+	} else {
+		rrw = &recordingResponseWriter{}
+	}
+	rrw.written = false
+	rrw.writtenBytes = 0
+	rrw.maxWrite = 0
+	rrw.status = 0
+	rrw.header = nil
+	rrw.routeTag = ""
+	rrw.spanName = ""
+	rrw.hijacked = false
+	rrw.writeErr = nil
+	rrw.next = nil
+	return rrw
+}
+
 func putRRW(rrw *recordingResponseWriter) {
 	rrw.writer = nil
+	rrw.writeErr = nil
+	rrw.next = nil
+	rrw.header = nil
 	rrwPool.Put(rrw)
 }
 
@@ -142,6 +584,12 @@ func (ow *otelware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// skip if method is skipped
+	if ow.skipMethods[strings.ToUpper(r.Method)] {
+		ow.handler.ServeHTTP(w, r)
+		return
+	}
+
 	// extract tracing header using propagator
 	ctx := ow.propagators.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
 	// create span, based on specification, we need to set already known attributes
@@ -154,77 +602,967 @@ func (ow *otelware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// if we have access to chi routes, we could extract the route pattern beforehand.
 	spanName := ""
 	routePattern := ""
+	var matchedRctx *chi.Context
 	if ow.chiRoutes != nil {
-		rctx := chi.NewRouteContext()
-		if ow.chiRoutes.Match(rctx, r.Method, r.URL.Path) {
-			routePattern = rctx.RoutePattern()
-			spanName = addPrefixToSpanName(ow.reqMethodInSpanName, r.Method, routePattern)
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && isEndpointPattern(rctx.RoutePattern()) {
+			// the request has already been routed (e.g the middleware is
+			// used inline with chi.With), so there is no need to match the
+			// routes again
+			matchedRctx = rctx
+			routePattern = ow.normalizeRoutePattern(rctx.RoutePattern())
+			spanName = ow.formatSpanName(r, routePattern)
+		} else if rctx := ow.matchChiRoutes(r); rctx != nil {
+			matchedRctx = rctx
+			routePattern = ow.normalizeRoutePattern(rctx.RoutePattern())
+			spanName = ow.formatSpanName(r, routePattern)
 		}
 	}
 
-	props := httpReqProperties{
-		Service: ow.serverName,
+	// skip if route filter returns false
+	if ow.routeFilter != nil && !ow.routeFilter(routePattern, r) {
+		ow.handler.ServeHTTP(w, r)
+		return
+	}
+
+	// resolve the server name of the request, e.g for virtual hosts
+	serverName := ow.serverName
+	if ow.serverNameResolver != nil {
+		if name := ow.serverNameResolver(r); name != "" {
+			serverName = name
+		}
+	}
+
+	props := HTTPReqProperties{
+		Service: serverName,
 		ID:      routePattern,
-		Method:  r.Method,
+		Method:  ow.requestMethod(r),
 	}
-	if routePattern == "" {
-		props.ID = r.URL.Path
+	if routePattern == "" && !ow.routeMetricID {
+		props.ID = ow.trimTrailingSlash(r.URL.Path)
 	}
 
-	if !ow.disableMeasureInflight {
-		ow.recorder.RecordRequestsInflight(ctx, props, 1)
-		defer ow.recorder.RecordRequestsInflight(ctx, props, -1)
+	if ow.protocolVersionMetric {
+		props.ProtocolVersion = protocolVersion(r)
 	}
 
-	ctx, span := ow.tracer.Start(
-		ctx, spanName,
-		oteltrace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...),
-		oteltrace.WithAttributes(semconv.EndUserAttributesFromHTTPRequest(r)...),
-		oteltrace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(ow.serverName, routePattern, r)...),
-		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
-	)
-	defer span.End()
+	peerService := ""
+	if ow.peerServiceHeader != "" {
+		peerService = truncateString(r.Header.Get(ow.peerServiceHeader), maxPeerServiceLength)
+		if ow.peerServiceMetric {
+			props.PeerService = peerService
+		}
+	}
+
+	reqContentType := ""
+	if ow.reqContentType {
+		reqContentType = mediaType(r.Header.Get("Content-Type"))
+		if ow.contentTypeMetric {
+			props.RequestContentType = reqContentType
+		}
+	}
+
+	// resolve the original scheme of the request
+	scheme := ""
+	if ow.schemeFromProxyHeaders {
+		if isTrustedProxy(ow.trustedProxies, r) {
+			scheme = proxyScheme(r)
+		}
+		if scheme == "" {
+			scheme = requestScheme(r)
+		}
+		props.Scheme = scheme
+	}
+
+	// start the span, when tracing is disabled or the request is not sampled
+	// the span is a non-recording one, so the span related work below is
+	// skipped
+	spanStart := time.Now()
+	span := oteltrace.SpanFromContext(context.Background())
+	switch {
+	case ow.tracingDisabled:
+	case ow.sampleDecision != nil && !ow.sampleDecision(r):
+		ctx = unsampledContext(ctx)
+		span = oteltrace.SpanFromContext(ctx)
+	default:
+		spanOpts := ow.spanStartOptions(r, serverName, routePattern, scheme, peerService)
+		spanOpts = append(spanOpts, oteltrace.WithTimestamp(spanStart))
+		if ow.publicEndpoint || (ow.publicEndpointFn != nil && ow.publicEndpointFn(r)) {
+			// the propagated span context is not trusted, so it is linked
+			// to a new root span instead of being its parent
+			spanOpts = append(spanOpts, oteltrace.WithNewRoot())
+			if remote := oteltrace.SpanContextFromContext(ctx); remote.IsValid() && remote.IsRemote() {
+				spanOpts = append(spanOpts, oteltrace.WithLinks(oteltrace.Link{SpanContext: remote}))
+			}
+		}
+		// put the user provided options last, so they take precedence
+		spanOpts = append(spanOpts, ow.spanOptions...)
+		if ow.attrValueLimit > 0 {
+			spanOpts = limitSpanStartOptions(spanOpts, ow.attrValueLimit)
+		}
+		ctx, span = ow.tracerFor(r).Start(ctx, spanName, spanOpts...)
+		defer span.End()
+		if ow.attrValueLimit > 0 {
+			span = &limitedSpan{Span: span, limit: ow.attrValueLimit}
+		}
+	}
+	recording := span.IsRecording()
+
+	// the measurements are made with the context carrying the server span,
+	// so the exemplars are linked to it
+	if ow.recorder != nil && !ow.disableMeasureInflight {
+		// the in-flight requests are labeled before the handler runs, so
+		// the raw path would bypass the unmatched label
+		inflightProps := props
+		if routePattern == "" && (ow.unmatchedLabelSet || ow.routeMetricID) {
+			inflightProps.ID = ""
+			// the route may exist for another method, then chi responds
+			// with 405 & the route is only known once the handler is done
+			if ow.chiRoutes != nil && ow.methodNotAllowedPattern(r) == "" {
+				inflightProps.ID = ow.unmatchedRouteLabel
+			}
+		}
+		inflightProps = ow.withMetricAttributes(inflightProps, r)
+		ow.recorder.RecordRequestsInflight(ctx, inflightProps, 1)
+		defer ow.recorder.RecordRequestsInflight(ctx, inflightProps, -1)
+	}
+
+	if recording {
+		ow.setRequestAttributes(span, r, routePattern)
+
+		if reqContentType != "" {
+			span.SetAttributes(requestContentTypeKey.String(reqContentType))
+		}
+
+		// put baggage members extracted from the request to span attributes
+		if ow.baggageAttributes {
+			span.SetAttributes(baggageAttributes(ctx, ow.baggageKeys)...)
+		}
+
+		// put request id to span attributes, only the one set by chi
+		// RequestID middleware placed before this middleware is visible,
+		// the middlewares placed after pass it to the handler through a
+		// request copy this middleware never sees
+		if ow.requestIDAttribute {
+			id := chimiddleware.GetReqID(r.Context())
+			if id == "" && ow.requestIDHeader != "" {
+				id = r.Header.Get(ow.requestIDHeader)
+			}
+			if id != "" {
+				span.SetAttributes(requestIDKey.String(id))
+			}
+		}
+	}
 
 	// put trace_id to response header
 	if span.SpanContext().HasTraceID() {
 		w.Header().Add(ow.traceResponseHeaderKey, span.SpanContext().TraceID().String())
 	}
 
+	// put the span context to response header using the propagators, this
+	// is done before executing the handler so the headers aren't sent yet
+	if ow.injectResponseCtx {
+		ow.propagators.Inject(ctx, propagation.HeaderCarrier(w.Header()))
+	}
+
 	// get recording response writer
-	rrw := getRRW(w)
-	defer putRRW(rrw)
+	var onFirstWrite func()
+	if recording && ow.ttfbEvent {
+		onFirstWrite = func() {
+			now := time.Now()
+			span.AddEvent("http.first_write",
+				oteltrace.WithTimestamp(now),
+				oteltrace.WithAttributes(firstWriteElapsedKey.Float64(
+					float64(now.Sub(spanStart))/float64(time.Millisecond),
+				)),
+			)
+		}
+	}
+	var rrw *recordingResponseWriter
+	if ow.statusOnlyWriter && onFirstWrite == nil {
+		rrw = getStatusRRW(w, r.ProtoMajor, !ow.disableRRWPool)
+	} else {
+		rrw = getRRW(w, ow.snapshotHeaderAttrs, !ow.disableRRWPool, onFirstWrite)
+	}
+	if !ow.disableRRWPool {
+		defer putRRW(rrw)
+	}
 
 	// execute next http handler
-	r = r.WithContext(ctx)
+	r = r.WithContext(context.WithValue(ctx, rrwContextKey{}, rrw))
+
+	// put request content length to span attributes, when the length is
+	// unknown count the bytes read by the handler instead
+	var reqBody *countingReadCloser
+	if recording && !ow.disableReqContentLen {
+		if r.ContentLength >= 0 {
+			if ow.semConvStability.emitOld() {
+				span.SetAttributes(semconv.HTTPRequestContentLengthKey.Int64(r.ContentLength))
+			}
+			if ow.semConvStability.emitStable() {
+				span.SetAttributes(semconvstable.HTTPRequestBodySize(int(r.ContentLength)))
+			}
+		} else if r.Body != nil && r.Body != http.NoBody {
+			// r is a shallow copy, so the original request is untouched
+			reqBody = &countingReadCloser{ReadCloser: r.Body}
+			r.Body = reqBody
+		}
+	}
 	start := time.Now()
+
+	// finish records the metrics & completes the span once the handler
+	// is done, it is also used when the handler panics
+	finish := func() {
+		duration := time.Since(start)
+		// the server responds with 200 when the handler doesn't write
+		// anything
+		if rrw.status == 0 && !rrw.hijacked {
+			rrw.status = http.StatusOK
+		}
+
+		// resolve the route pattern if necessary
+		routeResolved := false
+		if rrw.routeTag != "" {
+			routePattern = ow.normalizeRoutePattern(rrw.routeTag)
+			routeResolved = true
+		} else if len(routePattern) == 0 {
+			// there is no route context when the middleware wraps a handler
+			// outside of chi router
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				routePattern = ow.normalizeRoutePattern(rctx.RoutePattern())
+			}
+			// chi doesn't record the route pattern when the method is not
+			// allowed, yet the route exists
+			if routePattern == "" && rrw.status == http.StatusMethodNotAllowed {
+				if pattern := ow.methodNotAllowedPattern(r); pattern != "" {
+					routePattern = ow.normalizeRoutePattern(pattern)
+				}
+			}
+			routeResolved = true
+		}
+
+		// no route matches the request (e.g 404 from scanners), use the
+		// fallback instead of the raw path to keep the cardinality low
+		unmatched := routePattern == ""
+
+		props.Code = rrw.status
+		props.Route = routePattern
+
+		// the client went away before the handler was done
+		clientClosed := ow.clientDisconnects && r.Context().Err() == context.Canceled
+		if clientClosed && ow.clientClosedStatus && !rrw.written {
+			props.Code = statusClientClosedRequest
+		}
+
+		// the request deadline (e.g set by chi middleware.Timeout placed
+		// before this middleware) is exceeded, chi middleware.Timeout placed
+		// after this middleware responds with 504 instead
+		timedOut := ow.recordTimeouts && (r.Context().Err() == context.DeadlineExceeded ||
+			ow.gatewayTimeoutStatus && rrw.status == http.StatusGatewayTimeout)
+		props.TimedOut = timedOut
+		if unmatched {
+			props.ID = ow.unmatchedRouteLabel
+		} else if ow.routeMetricID {
+			props.ID = routePattern
+		}
+
+		respContentType := ""
+		if ow.respContentType {
+			respContentType = mediaType(rrw.responseHeader().Get("Content-Type"))
+			if ow.contentTypeMetric {
+				props.ResponseContentType = respContentType
+			}
+		}
+
+		cacheStatus := ""
+		if ow.cacheStatusHeader != "" {
+			cacheStatus = strings.ToLower(rrw.responseHeader().Get(ow.cacheStatusHeader))
+			if ow.cacheStatusMetric {
+				props.CacheStatus = cacheStatus
+			}
+		}
+		if ow.recorder != nil {
+			props = ow.withMetricAttributes(props, r)
+			if !ow.skipPreflightDuration || !isCORSPreflight(r) {
+				ow.recorder.RecordRequestDuration(ctx, props, duration)
+			}
+
+			// the size of the response written to the hijacked connection
+			// is unknown
+			if !ow.disableMeasureSize && !rrw.hijacked {
+				ow.recorder.RecordResponseSize(ctx, props, rrw.writtenBytes)
+			}
+		}
+
+		if ow.requestLogger != nil {
+			info := RequestInfo{
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Status:   rrw.status,
+				Duration: duration,
+				TraceID:  span.SpanContext().TraceID(),
+				SpanID:   span.SpanContext().SpanID(),
+			}
+			if !unmatched {
+				info.Route = routePattern
+			}
+			ow.requestLogger(info)
+		}
+
+		if !recording {
+			return
+		}
+
+		if clientClosed {
+			span.AddEvent("client.disconnected")
+			span.SetAttributes(clientClosedRequestKey.Bool(true))
+		}
+
+		// the handler usually ignores the failed writes, so the response
+		// looks successful otherwise
+		if rrw.writeErr != nil {
+			span.RecordError(rrw.writeErr)
+			span.SetAttributes(responseWriteErrorKey.Bool(true))
+		}
+
+		if timedOut {
+			var eventAttrs []attribute.KeyValue
+			if deadline, ok := r.Context().Deadline(); ok {
+				eventAttrs = append(eventAttrs, requestTimeoutKey.Float64(
+					float64(deadline.Sub(start))/float64(time.Millisecond),
+				))
+			}
+			span.AddEvent("timeout", oteltrace.WithAttributes(eventAttrs...))
+			span.SetAttributes(eventAttrs...)
+		}
+
+		// set span name & http route attribute if necessary
+		if routeResolved && !unmatched {
+			span.SetAttributes(semconv.HTTPRouteKey.String(routePattern))
+		}
+		switch {
+		case rrw.spanName != "":
+			// the name set by the handler with SetSpanName wins
+			span.SetName(rrw.spanName)
+		case ow.preflightSpanName != "" && isCORSPreflight(r):
+			span.SetName(ow.withServerNamePrefix(ow.preflightSpanName))
+		case unmatched:
+			span.SetName(ow.formatSpanName(r, ow.unmatchedRouteName))
+		case routeResolved:
+			spanName = ow.formatSpanName(r, routePattern)
+			span.SetName(spanName)
+		}
+
+		// put captured response headers to span attributes
+		if len(ow.responseHeaderAttrs) > 0 {
+			span.SetAttributes(headerAttributesFrom(ow.responseHeaderAttrs, rrw.responseHeader(), ow.headerRedactor)...)
+		}
+
+		if cacheStatus != "" {
+			span.SetAttributes(cacheStatusKey.String(cacheStatus))
+		}
+
+		if respContentType != "" {
+			span.SetAttributes(responseContentTypeKey.String(respContentType))
+		}
+
+		if rrw.status > 0 {
+			// set status code attribute
+			if ow.semConvStability.emitOld() {
+				span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rrw.status))
+			}
+			if ow.semConvStability.emitStable() {
+				span.SetAttributes(semconvstable.HTTPResponseStatusCodeKey.Int(rrw.status))
+			}
+		}
+
+		// put rate limit headers to span attributes, by default only for the
+		// responses that usually carry them
+		if ow.rateLimitAttributes && (ow.rateLimitAlways || isRateLimitStatus(rrw.status)) {
+			span.SetAttributes(rateLimitAttributes(rrw.writer.Header())...)
+		}
+
+		// put response content length to span attributes
+		if ow.respContentLen {
+			if size, ok := responseContentLength(rrw); ok {
+				if ow.semConvStability.emitOld() {
+					span.SetAttributes(semconv.HTTPResponseContentLengthKey.Int64(size))
+				}
+				if ow.semConvStability.emitStable() {
+					span.SetAttributes(semconvstable.HTTPResponseBodySize(int(size)))
+				}
+			}
+		}
+
+		if reqBody != nil && reqBody.n > 0 {
+			if ow.semConvStability.emitOld() {
+				span.SetAttributes(semconv.HTTPRequestContentLengthKey.Int64(reqBody.n))
+			}
+			if ow.semConvStability.emitStable() {
+				span.SetAttributes(semconvstable.HTTPRequestBodySize(int(reqBody.n)))
+			}
+		}
+
+		if ow.peakWriteSizeAttribute && rrw.maxWrite > 0 {
+			span.SetAttributes(responseMaxWriteBytesKey.Int(rrw.maxWrite))
+		}
+
+		// put allowed url params to span attributes, the params are only
+		// complete after the handler is executed, when the middleware is not
+		// used inside chi router use the params from pre-matched routes
+		if len(ow.urlParamAttrs) > 0 || ow.allURLParams {
+			rctx := chi.RouteContext(r.Context())
+			if rctx == nil {
+				rctx = matchedRctx
+			}
+			if rctx != nil && ow.allURLParams {
+				span.SetAttributes(allURLParamAttributes(rctx)...)
+			} else if rctx != nil {
+				span.SetAttributes(urlParamAttributesFrom(ow.urlParamAttrs, rctx)...)
+			}
+		}
+
+		// set span status, the status of the response written to the
+		// hijacked connection is unknown so the span status is left unset
+		if rrw.hijacked {
+			span.SetAttributes(connectionHijackedKey.Bool(true))
+		} else {
+			var spanStatus codes.Code
+			var spanMessage string
+			if ow.spanStatusMapper != nil {
+				spanStatus, spanMessage = ow.spanStatusMapper(rrw.status)
+			} else {
+				spanStatus, spanMessage = spanStatusFromHTTPStatusCode(rrw.status, ow.strictStatusMapping)
+				if ow.errorStatusCodes[rrw.status] {
+					spanStatus = codes.Error
+				}
+			}
+			// the status carried by the trailer (e.g gRPC-Web) replaces the
+			// one derived from the HTTP status code
+			if ow.trailerStatusKey != "" {
+				if value, ok := trailerValue(rrw.writer.Header(), ow.trailerStatusKey); ok {
+					spanStatus, spanMessage = ow.trailerStatusFn(value)
+				}
+			}
+			span.SetStatus(spanStatus, spanMessage)
+			// the server errors are described by their status code
+			if spanStatus == codes.Error && rrw.status >= 500 {
+				span.SetAttributes(semconvstable.ErrorTypeKey.String(strconv.Itoa(rrw.status)))
+			}
+		}
+		if timedOut {
+			span.SetStatus(codes.Error, "deadline exceeded")
+		}
+
+		// record logical error reported by the handler
+		if ow.handlerError != nil {
+			if err := ow.handlerError(r.Context()); err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+	}
+
+	if !ow.disableRecordPanics {
+		defer func() {
+			if rec := recover(); rec != nil {
+				// nothing has been written yet, so the server (or this
+				// middleware when not panicking again) will respond with
+				// 500
+				written := rrw.written
+				if !written {
+					rrw.status = http.StatusInternalServerError
+				}
+				finish()
+				span.RecordError(fmt.Errorf("%v", rec), oteltrace.WithStackTrace(true))
+				span.SetStatus(codes.Error, fmt.Sprint(rec))
+				// the type of the panic value describes the error better
+				// than the status code
+				span.SetAttributes(semconvstable.ErrorTypeKey.String(fmt.Sprintf("%T", rec)))
+				// end the span before panicking again, otherwise the SDK
+				// will record the same panic once more on span.End()
+				span.End()
+				if ow.disableRepanic {
+					if !written {
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+					return
+				}
+				panic(rec)
+			}
+		}()
+	}
+
 	ow.handler.ServeHTTP(rrw.writer, r)
+	finish()
+}
 
-	duration := time.Since(start)
+// spanStartOptions returns the options of the server span, including the
+// attributes already known when the span is created.
+func (ow *otelware) spanStartOptions(r *http.Request, serverName, routePattern, scheme, peerService string) []oteltrace.SpanStartOption {
+	spanOpts := []oteltrace.SpanStartOption{
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+	}
+	if ow.semConvStability.emitOld() {
+		spanOpts = append(spanOpts,
+			oteltrace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...),
+			oteltrace.WithAttributes(semconv.EndUserAttributesFromHTTPRequest(r)...),
+			oteltrace.WithAttributes(ow.queryRedactor.redactAttributes(withoutAttributes(
+				semconv.HTTPServerAttributesFromHTTPRequest(serverName, routePattern, r),
+				// these are configurable, so they are set separately
+				semconv.HTTPUserAgentKey,
+				semconv.HTTPRequestContentLengthKey,
+			))...),
+		)
+	}
+	if ow.semConvStability.emitStable() {
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(ow.queryRedactor.redactAttributes(
+			httpServerStableAttributes(r),
+		)...))
+		if routePattern != "" {
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(semconv.HTTPRouteKey.String(routePattern)))
+		}
+	}
+	if attrs := ow.methodAttributes(r); len(attrs) > 0 {
+		// put after the semantic conventions attributes, so they take
+		// precedence over the original method
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(attrs...))
+	}
+	if ow.queryStringAttribute && !ow.semConvStability.emitStable() && r.URL.RawQuery != "" {
+		// the stable semantic conventions already carry the query
+		query := r.URL.RawQuery
+		if ow.queryRedactor != nil {
+			query = ow.queryRedactor.redactQuery(query)
+		}
+		if query != "" {
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(semconvstable.URLQuery(query)))
+		}
+	}
+	if ow.httpTarget {
+		// put after the semantic conventions attributes, so it takes
+		// precedence over the old semantic conventions http.target
+		target := r.RequestURI
+		if target == "" {
+			target = r.URL.RequestURI()
+		}
+		if ow.queryRedactor != nil {
+			target = ow.queryRedactor.redactTarget(target)
+		}
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(semconv.HTTPTargetKey.String(target)))
+	}
+	if !ow.disableUserAgent {
+		if ua := truncateString(r.UserAgent(), ow.userAgentMaxLength); ua != "" {
+			if ow.semConvStability.emitOld() {
+				spanOpts = append(spanOpts, oteltrace.WithAttributes(semconv.HTTPUserAgentKey.String(ua)))
+			}
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(semconvstable.UserAgentOriginal(ua)))
+		}
+	}
+	if ow.clientIPResolver != nil {
+		// override the client address derived by the semantic conventions
+		if clientIP := ow.clientIPResolver.clientIP(r); clientIP != "" {
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(ow.clientAddressAttributes(clientIP)...))
+		}
+	}
+	if ow.forwardedHeader && isTrustedProxy(ow.trustedProxies, r) {
+		// put after the other attributes, so the values from the Forwarded
+		// header take precedence
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(forwardedAttributes(r, ow.semConvStability)...))
+	}
+	if ow.clientIPFunc != nil {
+		// the user provided resolver takes precedence over everything
+		if clientIP := ow.clientIPFunc(r); clientIP != "" {
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(ow.clientAddressAttributes(clientIP)...))
+		}
+	}
+	if scheme != "" {
+		if ow.semConvStability.emitOld() {
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(semconv.HTTPSchemeKey.String(scheme)))
+		}
+		if ow.semConvStability.emitStable() {
+			spanOpts = append(spanOpts, oteltrace.WithAttributes(semconvstable.URLScheme(scheme)))
+		}
+	}
+	if peerService != "" {
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(semconv.PeerServiceKey.String(peerService)))
+	}
+	if ow.serverAddress && !ow.semConvStability.emitStable() {
+		// the stable conventions already carry these attributes
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(serverAddressAttributes(r)...))
+	}
+	if ow.deploymentSlot != "" {
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(deploymentSlotKey.String(ow.deploymentSlot)))
+	}
+	if ow.forceSampleRoutes[routePattern] {
+		spanOpts = append(spanOpts, oteltrace.WithAttributes(ForceSampleKey.Bool(true)))
+	}
+	return spanOpts
+}
+
+// setRequestAttributes puts the configured request related attributes to the
+// span.
+func (ow *otelware) setRequestAttributes(span oteltrace.Span, r *http.Request, routePattern string) {
+	// put end user extracted by the user provided extractor to span
+	// attributes, they override the ones derived from basic auth
+	if ow.endUserExtractor != nil {
+		if id, role, ok := ow.endUserExtractor(r); ok {
+			if id != "" {
+				span.SetAttributes(semconv.EnduserIDKey.String(id))
+			}
+			if role != "" {
+				span.SetAttributes(semconv.EnduserRoleKey.String(role))
+			}
+		}
+	}
+
+	// put origin request header to span attributes
+	if ow.recordOrigin {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			span.SetAttributes(httpRequestOriginKey.String(origin))
+		}
+	}
+
+	// put CORS related attributes
+	if ow.corsAttributes {
+		span.SetAttributes(corsAttributes(r)...)
+	}
 
-	props.Code = rrw.status
-	ow.recorder.RecordRequestDuration(ctx, props, duration)
+	// put number of request cookies to span attributes
+	if ow.cookieCountAttribute {
+		span.SetAttributes(requestCookieCountKey.Int(len(r.Cookies())))
+	}
 
-	if !ow.disableMeasureSize {
-		ow.recorder.RecordResponseSize(ctx, props, rrw.writtenBytes)
+	// put TLS connection attributes
+	if ow.tlsAttributes {
+		span.SetAttributes(tlsAttributes(r)...)
 	}
 
-	// set span name & http route attribute if necessary
-	if len(routePattern) == 0 {
-		routePattern = chi.RouteContext(r.Context()).RoutePattern()
-		span.SetAttributes(semconv.HTTPRouteKey.String(routePattern))
+	// put captured request headers to span attributes
+	if len(ow.requestHeaderAttrs) > 0 {
+		span.SetAttributes(headerAttributesFrom(ow.requestHeaderAttrs, r.Header, ow.headerRedactor)...)
+	}
 
-		spanName = addPrefixToSpanName(ow.reqMethodInSpanName, r.Method, routePattern)
-		span.SetName(spanName)
+	// put hashed session id to span attributes, the raw value must never
+	// leave the process
+	if ow.sessionCookieName != "" {
+		if cookie, err := r.Cookie(ow.sessionCookieName); err == nil && cookie.Value != "" {
+			span.SetAttributes(sessionIDHashKey.String(hashSessionID(cookie.Value)))
+		}
 	}
 
-	if rrw.status > 0 {
-		// set status code attribute
-		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rrw.status))
+	// put route concurrency limiter utilization to span attributes
+	if ow.concurrencyLimit != nil {
+		current, limit := ow.concurrencyLimit(routePattern)
+		span.SetAttributes(
+			routeConcurrencyCurrentKey.Int(current),
+			routeConcurrencyLimitKey.Int(limit),
+		)
 	}
+}
+
+// urlParamAttribute maps a recorded chi URL param to its span attribute key.
+type urlParamAttribute struct {
+	name string
+	key  attribute.Key
+}
 
-	// set span status
-	spanStatus, spanMessage := semconv.SpanStatusFromHTTPStatusCode(rrw.status)
-	span.SetStatus(spanStatus, spanMessage)
+func newURLParamAttributes(names []string) []urlParamAttribute {
+	if len(names) == 0 {
+		return nil
+	}
+	attrs := make([]urlParamAttribute, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, urlParamAttribute{
+			name: name,
+			key:  attribute.Key(urlParamAttributePrefix + name),
+		})
+	}
+	return attrs
+}
+
+// urlParamAttributesFrom returns span attributes for the recorded params that
+// are present in the route context.
+func urlParamAttributesFrom(paramAttrs []urlParamAttribute, rctx *chi.Context) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, pa := range paramAttrs {
+		// look from the last key, since it is the one set by the deepest
+		// sub-router, just like chi.Context.URLParam
+		for k := len(rctx.URLParams.Keys) - 1; k >= 0; k-- {
+			if rctx.URLParams.Keys[k] == pa.name {
+				attrs = append(attrs, pa.key.String(rctx.URLParams.Values[k]))
+				break
+			}
+		}
+	}
+	return attrs
+}
+
+// allURLParamAttributes returns span attributes for all the params of the
+// route context, the value set by the deepest sub-router wins.
+func allURLParamAttributes(rctx *chi.Context) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(rctx.URLParams.Keys))
+	seen := make(map[string]bool, len(rctx.URLParams.Keys))
+	for k := len(rctx.URLParams.Keys) - 1; k >= 0; k-- {
+		name := rctx.URLParams.Keys[k]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		attrs = append(attrs, attribute.String(urlParamAttributePrefix+name, rctx.URLParams.Values[k]))
+	}
+	return attrs
+}
+
+// trailerValue returns the value of the given trailer, whether it has been
+// declared in the Trailer header or set with the http.TrailerPrefix.
+func trailerValue(header http.Header, key string) (string, bool) {
+	// the keys with the prefix are not canonicalized
+	for name, values := range header {
+		if strings.HasPrefix(name, http.TrailerPrefix) && strings.EqualFold(name[len(http.TrailerPrefix):], key) && len(values) > 0 {
+			return values[0], true
+		}
+	}
+	for _, declared := range header.Values("Trailer") {
+		for _, name := range strings.Split(declared, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), key) {
+				if values := header.Values(key); len(values) > 0 {
+					return values[0], true
+				}
+				return "", false
+			}
+		}
+	}
+	return "", false
+}
+
+// isCORSPreflight reports whether the request is a CORS preflight request.
+func isCORSPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func corsAttributes(r *http.Request) []attribute.KeyValue {
+	if isCORSPreflight(r) {
+		attrs := []attribute.KeyValue{
+			corsPreflightKey.Bool(true),
+			corsRequestMethodKey.String(r.Header.Get("Access-Control-Request-Method")),
+		}
+		if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			attrs = append(attrs, corsRequestHeadersKey.String(headers))
+		}
+		return attrs
+	}
+	if r.Header.Get("Origin") != "" {
+		return []attribute.KeyValue{corsActualKey.Bool(true)}
+	}
+	return nil
+}
+
+// newHandlerError returns the function extracting the error produced by the
+// handler, the function given to WithErrorFromContext takes precedence over
+// the key given to WithHandlerErrorFromContext.
+func newHandlerError(fn func(ctx context.Context) error, key interface{}) func(ctx context.Context) error {
+	if fn != nil {
+		return fn
+	}
+	if key != nil {
+		return func(ctx context.Context) error {
+			return handlerErrorFromContext(ctx, key)
+		}
+	}
+	return nil
+}
+
+func handlerErrorFromContext(ctx context.Context, key interface{}) error {
+	switch v := ctx.Value(key).(type) {
+	case error:
+		return v
+	case *error:
+		if v != nil {
+			return *v
+		}
+	}
+	return nil
+}
+
+// countingReadCloser counts the bytes read from the wrapped body.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func newErrorStatusCodes(statusCodes []int) map[int]bool {
+	if len(statusCodes) == 0 {
+		return nil
+	}
+	errorStatusCodes := make(map[int]bool, len(statusCodes))
+	for _, code := range statusCodes {
+		errorStatusCodes[code] = true
+	}
+	return errorStatusCodes
+}
+
+// recordWrite accounts for a write of the response body. Unlike the status,
+// which is only set by the first write, every write adds to the written bytes
+// so a streamed response reports the bytes sent so far on each flush.
+func (rrw *recordingResponseWriter) recordWrite(n int64, err error) {
+	rrw.writtenBytes += n
+	if err != nil && rrw.writeErr == nil {
+		rrw.writeErr = err
+	}
+}
+
+// responseHeader returns the captured response headers, when the response
+// hasn't been written, the headers are still in the header map.
+func (rrw *recordingResponseWriter) responseHeader() http.Header {
+	if !rrw.written {
+		return rrw.writer.Header()
+	}
+	return rrw.header
+}
+
+// responseContentLength returns the number of bytes written by the handler,
+// falling back to the Content-Length response header when the body bypassed
+// Write. It returns false when the length is unknown.
+func responseContentLength(rrw *recordingResponseWriter) (int64, bool) {
+	if rrw.writtenBytes > 0 {
+		return rrw.writtenBytes, true
+	}
+	if cl := rrw.writer.Header().Get("Content-Length"); cl != "" {
+		if size, err := strconv.ParseInt(cl, 10, 64); err == nil && size >= 0 {
+			return size, true
+		}
+	}
+	return 0, rrw.written
+}
+
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
+
+// matchChiRoutes matches the request against the chi routes. When the
+// middleware is used in a mounted sub-router, the remaining routing path is
+// matched and the mount prefix is kept in the route pattern. The HEAD requests
+// are matched against the GET routes as well when WithHeadAsGet is active.
+func (ow *otelware) matchChiRoutes(r *http.Request) *chi.Context {
+	if rctx := ow.matchChiRoutesMethod(r, r.Method); rctx != nil {
+		return rctx
+	}
+	if ow.headAsGet && r.Method == http.MethodHead {
+		return ow.matchChiRoutesMethod(r, http.MethodGet)
+	}
+	return nil
+}
+
+// methodNotAllowedPattern returns the pattern of the route matching the
+// request path with another method, or an empty string when there is none.
+// The routes given to WithChiRoutes are used when set, otherwise the routes
+// of the chi router serving the request.
+func (ow *otelware) methodNotAllowedPattern(r *http.Request) string {
+	parent := chi.RouteContext(r.Context())
+	if ow.chiRoutes == nil && (parent == nil || parent.Routes == nil) {
+		return ""
+	}
+	for _, method := range standardMethods {
+		if method == r.Method {
+			continue
+		}
+		if ow.chiRoutes != nil {
+			if rctx := ow.matchChiRoutesMethod(r, method); rctx != nil {
+				return rctx.RoutePattern()
+			}
+			continue
+		}
+		rctx := chi.NewRouteContext()
+		if parent.Routes.Match(rctx, method, r.URL.Path) {
+			return rctx.RoutePattern()
+		}
+	}
+	return ""
+}
+
+func (ow *otelware) matchChiRoutesMethod(r *http.Request, method string) *chi.Context {
+	if parent := chi.RouteContext(r.Context()); parent != nil && parent.RoutePath != "" {
+		rctx := chi.NewRouteContext()
+		rctx.RoutePatterns = append(rctx.RoutePatterns, parent.RoutePatterns...)
+		if ow.chiRoutes.Match(rctx, method, parent.RoutePath) {
+			return rctx
+		}
+	}
+	rctx := chi.NewRouteContext()
+	if ow.chiRoutes.Match(rctx, method, r.URL.Path) {
+		return rctx
+	}
+	return nil
+}
+
+// isEndpointPattern reports whether the given route pattern of an existing
+// route context is the pattern of the final route. Patterns ending with a
+// wildcard may be the prefix of a mounted sub-router which routing is not done
+// yet, so they are not trusted.
+func isEndpointPattern(routePattern string) bool {
+	return routePattern != "" && !strings.HasSuffix(routePattern, "/*")
+}
+
+// normalizeRoutePattern applies the user provided transformer to the given
+// resolved route pattern, then trims its trailing wildcard when
+// WithTrimTrailingWildcard is active, the root wildcard `/*` becomes `/`. The
+// trailing slash is trimmed as well when WithTrimTrailingSlash is active.
+func (ow *otelware) normalizeRoutePattern(routePattern string) string {
+	if ow.routeTransformer != nil && routePattern != "" {
+		routePattern = ow.routeTransformer(routePattern)
+	}
+	if ow.trimTrailingWildcard {
+		if routePattern == "/*" {
+			routePattern = "/"
+		}
+		routePattern = strings.TrimSuffix(routePattern, "/*")
+	}
+	return ow.trimTrailingSlash(routePattern)
+}
+
+// trimTrailingSlash trims a single trailing slash of the given route pattern
+// or path when WithTrimTrailingSlash is active, the root `/` is left as is.
+func (ow *otelware) trimTrailingSlash(path string) string {
+	if !ow.trimSlash || path == "/" {
+		return path
+	}
+	return strings.TrimSuffix(path, "/")
+}
+
+// withMetricAttributes returns the given properties carrying the metric
+// attributes returned by the function given to WithMetricAttributes.
+func (ow *otelware) withMetricAttributes(p HTTPReqProperties, r *http.Request) HTTPReqProperties {
+	if ow.metricAttributes == nil {
+		return p
+	}
+	// non-nil even when no attribute is returned, so the default ones are
+	// still replaced
+	p.attrs = append([]attribute.KeyValue{}, ow.metricAttributes(p, r)...)
+	return p
+}
+
+// formatSpanName returns the span name for the given route pattern, using the
+// user provided formatter if any.
+func (ow *otelware) formatSpanName(r *http.Request, routePattern string) string {
+	if ow.spanNameFormatter != nil {
+		return ow.withServerNamePrefix(ow.spanNameFormatter(ow.requestMethod(r), routePattern, r))
+	}
+	return ow.withServerNamePrefix(addPrefixToSpanName(ow.reqMethodInSpanName, ow.requestMethod(r), routePattern))
+}
+
+// withServerNamePrefix prefixes the given span name with the server name when
+// WithServerNameInSpanName is active.
+func (ow *otelware) withServerNamePrefix(spanName string) string {
+	if !ow.serverNameInSpanName {
+		return spanName
+	}
+	return ow.serverName + ": " + spanName
 }
 
 func addPrefixToSpanName(shouldAdd bool, prefix, spanName string) string {
@@ -3,21 +3,34 @@ package otelchi
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
 	"go.opentelemetry.io/otel/propagation"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 var sc = trace.NewSpanContext(trace.SpanContextConfig{
@@ -152,6 +165,180 @@ func TestResponseWriterInterfaces(t *testing.T) {
 	router.ServeHTTP(w, r)
 }
 
+func TestResponseWriterInterfacesStatusOnly(t *testing.T) {
+	// the lighter writer used when the size isn't measured preserves the
+	// interfaces supported by the protocol of the request
+	testCases := []struct {
+		Name       string
+		ProtoMajor int
+		Interfaces []interface{}
+	}{
+		{
+			Name:       "HTTP/1.1",
+			ProtoMajor: 1,
+			Interfaces: []interface{}{(*http.Hijacker)(nil), (*http.Flusher)(nil), (*io.ReaderFrom)(nil)},
+		},
+		{
+			Name:       "HTTP/2",
+			ProtoMajor: 2,
+			Interfaces: []interface{}{(*http.Pusher)(nil), (*http.Flusher)(nil)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", WithMeasureSize(true)))
+			router.HandleFunc("/user/{id}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, iface := range testCase.Interfaces {
+					assert.Implements(t, iface, w)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest("GET", "/user/123", nil)
+			r.ProtoMajor = testCase.ProtoMajor
+			w := &testResponseWriter{
+				writer: httptest.NewRecorder(),
+			}
+
+			router.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestSDKIntegrationWithResponseBypassingWrite(t *testing.T) {
+	// flushing & io.Copy send the response without calling Write
+	testCases := []struct {
+		Name           string
+		Handler        http.HandlerFunc
+		ExpectedLength int64
+	}{
+		{
+			Name: "Flush",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.(http.Flusher).Flush()
+			},
+		},
+		{
+			Name: "ReadFrom",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.(io.ReaderFrom).ReadFrom(strings.NewReader("hello"))
+			},
+			ExpectedLength: 5,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar",
+				WithTracerProvider(provider),
+				WithResponseHeaderAttributes("Content-Type"),
+				WithResponseContentLengthAttribute(true),
+			))
+			router.HandleFunc("/events", testCase.Handler)
+
+			r0 := httptest.NewRequest("GET", "/events", nil)
+			w := &readerFromRecorder{httptest.NewRecorder()}
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Contains(t, span.Attributes(), attribute.Int("http.status_code", http.StatusOK))
+			assert.Contains(t, span.Attributes(), attribute.StringSlice("http.response.header.content-type", []string{"text/event-stream"}))
+			assert.Contains(t, span.Attributes(), attribute.Int64("http.response_content_length", testCase.ExpectedLength))
+		})
+	}
+}
+
+// readerFromRecorder is a recorder implementing io.ReaderFrom, like the
+// writer of the net/http server does.
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+var errBrokenPipe = errors.New("write: broken pipe")
+
+// failingResponseWriter fails the writes after the given number of bytes,
+// like a connection reset by the client does.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *failingResponseWriter) Write(b []byte) (int, error) {
+	if w.ResponseRecorder.Body.Len()+len(b) > w.limit {
+		n, _ := w.ResponseRecorder.Write(b[:w.limit-w.ResponseRecorder.Body.Len()])
+		return n, errBrokenPipe
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestSDKIntegrationWithWriteError(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Options       []Option
+		ExpectedSizes int
+	}{
+		{
+			Name:          "Default",
+			ExpectedSizes: 1,
+		},
+		{
+			Name:    "Size not measured",
+			Options: []Option{WithMeasureSize(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append([]Option{
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithResponseContentLengthAttribute(true),
+			}, testCase.Options...)...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+				w.Write([]byte(", world"))
+				w.Write([]byte("!"))
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := &failingResponseWriter{ResponseRecorder: httptest.NewRecorder(), limit: 8}
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Contains(t, span.Attributes(), attribute.Bool("http.response.write_error", true))
+			// only the bytes actually written are counted
+			assert.Contains(t, span.Attributes(), attribute.Int("http.response_content_length", 8))
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, "exception", span.Events()[0].Name)
+			assert.Contains(t, span.Events()[0].Attributes, attribute.String("exception.message", errBrokenPipe.Error()))
+
+			sizes := meterProvider.Measurements("response_size_bytes")
+			require.Len(t, sizes, testCase.ExpectedSizes)
+			for _, size := range sizes {
+				assert.Equal(t, int64(8), size.value)
+			}
+		})
+	}
+}
+
 func ok(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
@@ -409,18 +596,5479 @@ func TestSDKIntegrationWithRequestMethodInSpanName(t *testing.T) {
 	)
 }
 
-func assertSpan(t *testing.T, span sdktrace.ReadOnlySpan, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) {
-	assert.Equal(t, name, span.Name())
-	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+func TestSDKIntegrationWithOriginAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
 
-	got := make(map[attribute.Key]attribute.Value, len(span.Attributes()))
-	for _, a := range span.Attributes() {
-		got[a.Key] = a.Value
-	}
-	for _, want := range attrs {
-		if !assert.Contains(t, got, want.Key) {
-			continue
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithOriginAttribute(true),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("Origin", "https://app.example.com")
+	r1 := httptest.NewRequest("GET", "/user/456", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.String("http.request.origin", "https://app.example.com"),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[1], "http.request.origin")
+}
+
+func TestSDKIntegrationWithSessionCookieAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithSessionCookieAttribute("session"),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	rawSessionID := "s3cr3t-session-id"
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.AddCookie(&http.Cookie{Name: "session", Value: rawSessionID})
+	r1 := httptest.NewRequest("GET", "/user/123", nil)
+	r1.AddCookie(&http.Cookie{Name: "session", Value: rawSessionID})
+	r2 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+	router.ServeHTTP(w, r2)
+
+	require.Len(t, sr.Ended(), 3)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.String("session.id_hash", hashSessionID(rawSessionID)),
+	)
+	// the hash must be stable across requests
+	assertSpan(t, sr.Ended()[1],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.String("session.id_hash", hashSessionID(rawSessionID)),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[2], "session.id_hash")
+
+	// the raw session id must never appear in the span
+	for _, span := range sr.Ended() {
+		for _, a := range span.Attributes() {
+			assert.NotContains(t, a.Value.Emit(), rawSessionID)
 		}
-		assert.Equal(t, got[want.Key], want.Value)
+	}
+}
+
+func TestSDKIntegrationWithResponseHeaderAttributes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithResponseHeaderAttributes("Content-Encoding", "x-cache", "Retry-After"),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Add("X-Cache", "MISS")
+		w.Header().Add("X-Cache", "STALE")
+		w.WriteHeader(http.StatusOK)
+
+		// mutation after the response is written must not be captured
+		w.Header().Set("X-Cache", "HIT")
+		w.Header().Set("Retry-After", "120")
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.StringSlice("http.response.header.content-encoding", []string{"gzip"}),
+		attribute.StringSlice("http.response.header.x-cache", []string{"MISS", "STALE"}),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[0], "http.response.header.retry-after")
+}
+
+func TestSDKIntegrationWithSemConvStability(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithSemConvStability(SemConvStabilityHTTP),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123?foo=bar", nil)
+	r0.Header.Set("User-Agent", "otelchi-test")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.String("http.request.method", "GET"),
+		attribute.Int("http.response.status_code", http.StatusOK),
+		attribute.String("http.route", "/user/{id:[0-9]+}"),
+		attribute.String("url.path", "/user/123"),
+		attribute.String("url.query", "foo=bar"),
+		attribute.String("url.scheme", "http"),
+		attribute.String("server.address", "example.com"),
+		attribute.String("network.peer.address", "192.0.2.1"),
+		attribute.Int("network.peer.port", 1234),
+		attribute.String("client.address", "192.0.2.1"),
+		attribute.String("user_agent.original", "otelchi-test"),
+		attribute.String("network.protocol.version", "1.1"),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[0],
+		"http.method",
+		"http.status_code",
+		"http.target",
+		"http.server_name",
+	)
+}
+
+func TestSDKIntegrationWithSemConvStabilityDup(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithChiRoutes(router),
+			WithSemConvStability(SemConvStabilityHTTPDup),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.String("http.server_name", "foobar"),
+		attribute.String("http.method", "GET"),
+		attribute.String("http.request.method", "GET"),
+		attribute.Int("http.status_code", http.StatusOK),
+		attribute.Int("http.response.status_code", http.StatusOK),
+		attribute.String("http.target", "/user/123"),
+		attribute.String("url.path", "/user/123"),
+		attribute.String("http.route", "/user/{id:[0-9]+}"),
+	)
+}
+
+func TestSDKIntegrationWithRecordPanics(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithMeterProvider(meterProvider),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, "something went wrong", func() {
+		router.ServeHTTP(w, r0)
+	})
+
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assertSpan(t, span,
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusInternalServerError),
+		attribute.String("http.route", "/user/{id:[0-9]+}"),
+	)
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Equal(t, "something went wrong", span.Status().Description)
+	require.Len(t, span.Events(), 1)
+	assert.Equal(t, "exception", span.Events()[0].Name)
+	assert.Contains(t, span.Attributes(), attribute.String("error.type", "string"))
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.Int("code", http.StatusInternalServerError))
+}
+
+func TestSDKIntegrationWithRecordPanicsAfterWriteHeader(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithMeterProvider(meterProvider),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("something went wrong")
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, "something went wrong", func() {
+		router.ServeHTTP(w, r0)
+	})
+
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assertSpan(t, span,
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusAccepted),
+	)
+	assert.Equal(t, codes.Error, span.Status().Code)
+	require.Len(t, span.Events(), 1)
+	assert.Equal(t, "exception", span.Events()[0].Name)
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.Int("code", http.StatusAccepted))
+}
+
+func TestSDKIntegrationWithRepanicDisabled(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		WriteHeader    bool
+		ExpectedStatus int
+	}{
+		{
+			Name:           "Panic before WriteHeader",
+			ExpectedStatus: http.StatusInternalServerError,
+		},
+		{
+			Name:           "Panic after WriteHeader",
+			WriteHeader:    true,
+			ExpectedStatus: http.StatusAccepted,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					WithTracerProvider(provider),
+					WithMeterProvider(meterProvider),
+					WithRepanic(false),
+				),
+			)
+			router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+				if testCase.WriteHeader {
+					w.WriteHeader(http.StatusAccepted)
+				}
+				panic("something went wrong")
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			assert.NotPanics(t, func() {
+				router.ServeHTTP(w, r0)
+			})
+			assert.Equal(t, testCase.ExpectedStatus, w.Code)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Equal(t, codes.Error, span.Status().Code)
+			assert.Contains(t, span.Attributes(), attribute.Int("http.status_code", testCase.ExpectedStatus))
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, "exception", span.Events()[0].Name)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.Int("code", testCase.ExpectedStatus))
+		})
+	}
+}
+
+func TestSDKIntegrationWithRecordPanicsDisabled(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithRecordPanics(false),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	assert.PanicsWithValue(t, "something went wrong", func() {
+		router.ServeHTTP(w, r0)
+	})
+
+	require.Len(t, sr.Ended(), 1)
+	assert.Equal(t, codes.Unset, sr.Ended()[0].Status().Code)
+}
+
+type handlerErrorKey struct{}
+
+func TestSDKIntegrationWithHandlerErrorFromContext(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	// this middleware provides the holder for the handler error
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			ctx := context.WithValue(r.Context(), handlerErrorKey{}, &err)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithHandlerErrorFromContext(handlerErrorKey{}),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		errPtr := r.Context().Value(handlerErrorKey{}).(*error)
+		*errPtr = errors.New("user not synced")
+		w.WriteHeader(http.StatusOK)
+	})
+	router.HandleFunc("/book/{title}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r1 := httptest.NewRequest("GET", "/book/foo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusOK),
+	)
+	assert.Equal(t, codes.Error, sr.Ended()[0].Status().Code)
+	assert.Equal(t, "user not synced", sr.Ended()[0].Status().Description)
+	require.Len(t, sr.Ended()[0].Events(), 1)
+	assert.Equal(t, "exception", sr.Ended()[0].Events()[0].Name)
+
+	assert.Equal(t, codes.Unset, sr.Ended()[1].Status().Code)
+	assert.Empty(t, sr.Ended()[1].Events())
+}
+
+// errorHolder is how some applications keep track of the handler error.
+type errorHolder struct {
+	err error
+}
+
+type errorHolderKey struct{}
+
+func TestSDKIntegrationWithErrorFromContext(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), errorHolderKey{}, &errorHolder{})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithErrorFromContext(func(ctx context.Context) error {
+				if holder, ok := ctx.Value(errorHolderKey{}).(*errorHolder); ok {
+					return holder.err
+				}
+				return nil
+			}),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		r.Context().Value(errorHolderKey{}).(*errorHolder).err = errors.New("user not synced")
+		w.WriteHeader(http.StatusOK)
+	})
+	router.HandleFunc("/book/{title}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r1 := httptest.NewRequest("GET", "/book/foo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assert.Equal(t, codes.Error, sr.Ended()[0].Status().Code)
+	assert.Equal(t, "user not synced", sr.Ended()[0].Status().Description)
+	require.Len(t, sr.Ended()[0].Events(), 1)
+	assert.Equal(t, "exception", sr.Ended()[0].Events()[0].Name)
+
+	assert.Equal(t, codes.Unset, sr.Ended()[1].Status().Code)
+	assert.Empty(t, sr.Ended()[1].Events())
+}
+
+func TestSDKIntegrationWithHeaderRedaction(t *testing.T) {
+	const bearerToken = "Bearer eyJhbGciOiJIUzI1NiJ9.secret"
+
+	testCases := []struct {
+		Name     string
+		Options  []Option
+		Expected []attribute.KeyValue
+		Dropped  []attribute.Key
+	}{
+		{
+			Name: "Default Redaction",
+			Expected: []attribute.KeyValue{
+				attribute.StringSlice("http.response.header.authorization", []string{"REDACTED"}),
+				attribute.StringSlice("http.response.header.set-cookie", []string{"REDACTED"}),
+				attribute.StringSlice("http.response.header.x-api-key", []string{"my-api-key"}),
+			},
+		},
+		{
+			Name: "Custom Redaction",
+			Options: []Option{
+				WithHeaderRedaction(func(name, value string) (string, bool) {
+					return value, name != "X-Api-Key"
+				}),
+			},
+			Expected: []attribute.KeyValue{
+				attribute.StringSlice("http.response.header.authorization", []string{"REDACTED"}),
+				attribute.StringSlice("http.response.header.set-cookie", []string{"REDACTED"}),
+			},
+			Dropped: []attribute.Key{"http.response.header.x-api-key"},
+		},
+		{
+			Name: "Default Redaction Disabled",
+			Options: []Option{
+				WithDefaultHeaderRedaction(false),
+			},
+			Expected: []attribute.KeyValue{
+				attribute.StringSlice("http.response.header.authorization", []string{bearerToken}),
+				attribute.StringSlice("http.response.header.set-cookie", []string{"session=abc"}),
+				attribute.StringSlice("http.response.header.x-api-key", []string{"my-api-key"}),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			opts := append([]Option{
+				WithTracerProvider(provider),
+				WithResponseHeaderAttributes("Authorization", "Set-Cookie", "X-Api-Key"),
+			}, testCase.Options...)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", opts...))
+			router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Authorization", bearerToken)
+				w.Header().Set("Set-Cookie", "session=abc")
+				w.Header().Set("X-Api-Key", "my-api-key")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id:[0-9]+}", trace.SpanKindServer, testCase.Expected...)
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.Dropped...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithHeaderRedactionNeverLeaksToken(t *testing.T) {
+	const bearerToken = "Bearer eyJhbGciOiJIUzI1NiJ9.secret"
+
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithRequestHeaderAttributes("Authorization"),
+		WithResponseHeaderAttributes("Authorization"),
+	))
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Authorization", bearerToken)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("Authorization", bearerToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	for _, a := range sr.Ended()[0].Attributes() {
+		assert.NotContains(t, a.Value.Emit(), "eyJhbGciOiJIUzI1NiJ9")
+	}
+}
+
+func TestSDKIntegrationWithConcurrencyLimitAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	// fake limiter with per route limits
+	limits := map[string]int{"/user/{id:[0-9]+}": 10, "/book/{title}": 5}
+	var routes []string
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithChiRoutes(router),
+			WithConcurrencyLimitAttribute(func(routePattern string) (int, int) {
+				routes = append(routes, routePattern)
+				return 3, limits[routePattern]
+			}),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+	router.HandleFunc("/book/{title}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r1 := httptest.NewRequest("GET", "/book/foo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	assert.Equal(t, []string{"/user/{id:[0-9]+}", "/book/{title}"}, routes)
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.Int("http.route.concurrency.current", 3),
+		attribute.Int("http.route.concurrency.limit", 10),
+	)
+	assertSpan(t, sr.Ended()[1],
+		"/book/{title}",
+		trace.SpanKindServer,
+		attribute.Int("http.route.concurrency.current", 3),
+		attribute.Int("http.route.concurrency.limit", 5),
+	)
+}
+
+func TestSDKIntegrationWithRequestHeaderAttributes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithRequestHeaderAttributes("User-Agent", "referer", "X-Request-Id", "X-Forwarded-For"),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("User-Agent", "otelchi-test")
+	r0.Header.Set("X-Request-Id", "abc-123")
+	r0.Header.Add("X-Forwarded-For", "192.0.2.1")
+	r0.Header.Add("X-Forwarded-For", "192.0.2.2")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.StringSlice("http.request.header.user-agent", []string{"otelchi-test"}),
+		attribute.StringSlice("http.request.header.x-request-id", []string{"abc-123"}),
+		attribute.StringSlice("http.request.header.x-forwarded-for", []string{"192.0.2.1", "192.0.2.2"}),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[0], "http.request.header.referer")
+}
+
+func TestSDKIntegrationWithURLParamAttributes(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		WithChiRoutes bool
+	}{
+		{Name: "Without Chi Routes", WithChiRoutes: false},
+		{Name: "With Chi Routes", WithChiRoutes: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			opts := []Option{
+				WithTracerProvider(provider),
+				WithURLParamAttributes("orgID"),
+			}
+			if testCase.WithChiRoutes {
+				opts = append(opts, WithChiRoutes(router))
+			}
+			router.Use(Middleware("foobar", opts...))
+			router.HandleFunc("/orgs/{orgID}/repos/{repoName}", ok)
+			router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+			r0 := httptest.NewRequest("GET", "/orgs/acme/repos/secret-project", nil)
+			r1 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+			router.ServeHTTP(w, r1)
+
+			require.Len(t, sr.Ended(), 2)
+			assertSpan(t, sr.Ended()[0],
+				"/orgs/{orgID}/repos/{repoName}",
+				trace.SpanKindServer,
+				attribute.String("http.route.param.orgID", "acme"),
+			)
+			assertSpanNoAttributes(t, sr.Ended()[0], "http.route.param.repoName")
+			assertSpanNoAttributes(t, sr.Ended()[1], "http.route.param.orgID", "http.route.param.id")
+		})
+	}
+}
+
+func TestSDKIntegrationWithURLParamAttributesOutsideRouter(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.HandleFunc("/orgs/{orgID}/repos/{repoName}", ok)
+
+	// the middleware wraps the router, so the params are only available
+	// from the pre-matched routes
+	handler := Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithChiRoutes(router),
+		WithURLParamAttributes("orgID"),
+	)(router)
+
+	r0 := httptest.NewRequest("GET", "/orgs/acme/repos/secret-project", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/orgs/{orgID}/repos/{repoName}",
+		trace.SpanKindServer,
+		attribute.String("http.route.param.orgID", "acme"),
+	)
+}
+
+func TestSDKIntegrationWithResponseHeaderAttributesWithoutWriteHeader(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithResponseHeaderAttributes("Content-Type", "X-Cache"),
+		),
+	)
+	// only call Write, the status is implicitly set
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Cache", "HIT")
+		_, _ = w.Write([]byte(`{"id":123}`))
+	})
+	// never write anything, the headers are sent by the server afterwards
+	router.HandleFunc("/book/{title}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "MISS")
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r1 := httptest.NewRequest("GET", "/book/foo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(httptest.NewRecorder(), r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.StringSlice("http.response.header.content-type", []string{"application/json"}),
+		attribute.StringSlice("http.response.header.x-cache", []string{"HIT"}),
+	)
+	assertSpan(t, sr.Ended()[1],
+		"/book/{title}",
+		trace.SpanKindServer,
+		attribute.StringSlice("http.response.header.x-cache", []string{"MISS"}),
+	)
+}
+
+func TestSDKIntegrationWithQueryParamRedaction(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		Target         string
+		ExpectedTarget string
+		ExpectedQuery  string
+	}{
+		{
+			Name:           "Single Param",
+			Options:        []Option{WithQueryParamRedaction("token", "api_key")},
+			Target:         "/user/123?token=s3cr3t&page=2",
+			ExpectedTarget: "/user/123?token=REDACTED&page=2",
+			ExpectedQuery:  "token=REDACTED&page=2",
+		},
+		{
+			Name:           "Repeated Params",
+			Options:        []Option{WithQueryParamRedaction("token", "api_key")},
+			Target:         "/user/123?api_key=a&page=2&api_key=b",
+			ExpectedTarget: "/user/123?api_key=REDACTED&page=2&api_key=REDACTED",
+			ExpectedQuery:  "api_key=REDACTED&page=2&api_key=REDACTED",
+		},
+		{
+			Name:           "Encoded Values",
+			Options:        []Option{WithQueryParamRedaction("token", "api_key")},
+			Target:         "/user/123?api%5Fkey=a%2Fb%3D&q=hello%20world",
+			ExpectedTarget: "/user/123?api%5Fkey=REDACTED&q=hello%20world",
+			ExpectedQuery:  "api%5Fkey=REDACTED&q=hello%20world",
+		},
+		{
+			Name:           "Drop Query String",
+			Options:        []Option{WithDropQueryString(true)},
+			Target:         "/user/123?token=s3cr3t&page=2",
+			ExpectedTarget: "/user/123",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			opts := append([]Option{
+				WithTracerProvider(provider),
+				WithSemConvStability(SemConvStabilityHTTPDup),
+			}, testCase.Options...)
+
+			var gotRequestURI string
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", opts...))
+			router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+				gotRequestURI = r.RequestURI
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r0 := httptest.NewRequest("GET", testCase.Target, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			// the request must not be mutated
+			assert.Equal(t, testCase.Target, gotRequestURI)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id:[0-9]+}",
+				trace.SpanKindServer,
+				attribute.String("http.target", testCase.ExpectedTarget),
+			)
+			if testCase.ExpectedQuery != "" {
+				assertSpan(t, sr.Ended()[0],
+					"/user/{id:[0-9]+}",
+					trace.SpanKindServer,
+					attribute.String("url.query", testCase.ExpectedQuery),
+				)
+			} else {
+				assertSpanNoAttributes(t, sr.Ended()[0], "url.query")
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithCORSAttributes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithCORSAttributes(true),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	// preflight request
+	r0 := httptest.NewRequest("OPTIONS", "/user/123", nil)
+	r0.Header.Set("Origin", "https://app.example.com")
+	r0.Header.Set("Access-Control-Request-Method", "PUT")
+	r0.Header.Set("Access-Control-Request-Headers", "content-type,x-request-id")
+	// actual cross-origin request
+	r1 := httptest.NewRequest("PUT", "/user/123", nil)
+	r1.Header.Set("Origin", "https://app.example.com")
+	// same-origin request
+	r2 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+	router.ServeHTTP(w, r2)
+
+	require.Len(t, sr.Ended(), 3)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.Bool("http.cors.preflight", true),
+		attribute.String("http.cors.request_method", "PUT"),
+		attribute.String("http.cors.request_headers", "content-type,x-request-id"),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[0], "http.cors.actual")
+	assertSpan(t, sr.Ended()[1],
+		"/user/{id:[0-9]+}",
+		trace.SpanKindServer,
+		attribute.Bool("http.cors.actual", true),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[1], "http.cors.preflight")
+	assertSpanNoAttributes(t, sr.Ended()[2], "http.cors.preflight", "http.cors.actual")
+}
+
+func TestSDKIntegrationWithPeakWriteSizeAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithPeakWriteSizeAttribute(true),
+		),
+	)
+	router.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(make([]byte, 16))
+		_, _ = w.Write(make([]byte, 1024))
+		_, _ = w.Write(make([]byte, 64))
+	})
+	router.HandleFunc("/empty", ok)
+
+	r0 := httptest.NewRequest("GET", "/stream", nil)
+	r1 := httptest.NewRequest("GET", "/empty", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/stream",
+		trace.SpanKindServer,
+		attribute.Int("http.response.max_write_bytes", 1024),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[1], "http.response.max_write_bytes")
+}
+
+func TestSDKIntegrationWithRouteTag(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		WithChiRoutes bool
+	}{
+		{Name: "Without Chi Routes", WithChiRoutes: false},
+		{Name: "With Chi Routes", WithChiRoutes: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			opts := []Option{
+				WithTracerProvider(provider),
+				WithRequestMethodInSpanName(true),
+			}
+			if testCase.WithChiRoutes {
+				opts = append(opts, WithChiRoutes(router))
+			}
+			router.Use(Middleware("foobar", opts...))
+			router.Handle("/rpc/*", WithRouteTag("/rpc/GetUser", http.HandlerFunc(ok)))
+
+			r0 := httptest.NewRequest("POST", "/rpc/v1/some/thing", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"POST /rpc/GetUser",
+				trace.SpanKindServer,
+				attribute.String("http.route", "/rpc/GetUser"),
+			)
+		})
+	}
+}
+
+func TestMetricsWithRouteTag(t *testing.T) {
+	// the metrics don't depend on the sampling decision
+	testCases := []struct {
+		Name    string
+		Options []Option
+	}{
+		{
+			Name:    "Sampled",
+			Options: []Option{WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample())))},
+		},
+		{
+			Name:    "Not sampled",
+			Options: []Option{WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.NeverSample())))},
+		},
+		{
+			Name:    "Tracing disabled",
+			Options: []Option{WithTracingDisabled(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithMeterProvider(meterProvider),
+				WithRouteMetricID(true),
+			)...))
+			router.Handle("/rpc/*", WithRouteTag("/rpc/GetUser", http.HandlerFunc(ok)))
+
+			r0 := httptest.NewRequest("POST", "/rpc/v1/some/thing", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.String("id", "/rpc/GetUser"))
+		})
+	}
+}
+
+func TestWithRouteTagWithoutMiddleware(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	handler := WithRouteTag("/rpc/GetUser", http.HandlerFunc(ok))
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "original")
+	r0 := httptest.NewRequest("POST", "/rpc/v1/some/thing", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r0)
+	span.End()
+
+	require.Len(t, sr.Ended(), 1)
+	assert.Equal(t, "/rpc/GetUser", sr.Ended()[0].Name())
+	assert.Contains(t, sr.Ended()[0].Attributes(), attribute.String("http.route", "/rpc/GetUser"))
+}
+
+func TestSDKIntegrationWithRequestContentLength(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithTracerProvider(provider)))
+	router.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// known content length
+	r0 := httptest.NewRequest("POST", "/upload", strings.NewReader("hello world"))
+	// empty body
+	r1 := httptest.NewRequest("POST", "/upload", nil)
+	// chunked body, the length is unknown
+	r2 := httptest.NewRequest("POST", "/upload", strings.NewReader("hello chunked world"))
+	r2.ContentLength = -1
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+	router.ServeHTTP(w, r2)
+
+	require.Len(t, sr.Ended(), 3)
+	assertSpan(t, sr.Ended()[0],
+		"/upload",
+		trace.SpanKindServer,
+		attribute.Int64("http.request_content_length", 11),
+	)
+	assertSpan(t, sr.Ended()[1],
+		"/upload",
+		trace.SpanKindServer,
+		attribute.Int64("http.request_content_length", 0),
+	)
+	assertSpan(t, sr.Ended()[2],
+		"/upload",
+		trace.SpanKindServer,
+		attribute.Int64("http.request_content_length", 19),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[2], "http.request.body.size")
+}
+
+func TestSDKIntegrationWithChunkedRequestBodySemConvStability(t *testing.T) {
+	// the bytes read from the chunked body follow the semantic conventions
+	// mode like the known content length does
+	testCases := []struct {
+		Name       string
+		Mode       SemConvStability
+		Expected   []attribute.KeyValue
+		Unexpected []attribute.Key
+	}{
+		{
+			Name:       "Old",
+			Mode:       SemConvStabilityDefault,
+			Expected:   []attribute.KeyValue{attribute.Int64("http.request_content_length", 19)},
+			Unexpected: []attribute.Key{"http.request.body.size"},
+		},
+		{
+			Name: "Dup",
+			Mode: SemConvStabilityHTTPDup,
+			Expected: []attribute.KeyValue{
+				attribute.Int64("http.request_content_length", 19),
+				attribute.Int("http.request.body.size", 19),
+			},
+		},
+		{
+			Name:       "Stable",
+			Mode:       SemConvStabilityHTTP,
+			Expected:   []attribute.KeyValue{attribute.Int("http.request.body.size", 19)},
+			Unexpected: []attribute.Key{"http.request_content_length"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar",
+				WithTracerProvider(provider),
+				WithSemConvStability(testCase.Mode),
+				WithRequestContentLengthAttribute(true),
+			))
+			router.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(ioutil.Discard, r.Body)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r0 := httptest.NewRequest("POST", "/upload", strings.NewReader("hello chunked world"))
+			r0.ContentLength = -1
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			for _, attr := range testCase.Expected {
+				assert.Contains(t, sr.Ended()[0].Attributes(), attr)
+			}
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.Unexpected...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithRequestContentLengthDisabled(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithRequestContentLengthAttribute(false),
+	))
+	router.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r0 := httptest.NewRequest("POST", "/upload", nil)
+	r1 := httptest.NewRequest("POST", "/upload", strings.NewReader("hello chunked world"))
+	r1.ContentLength = -1
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpanNoAttributes(t, sr.Ended()[0], "http.request_content_length")
+	assertSpanNoAttributes(t, sr.Ended()[1], "http.request_content_length", "http.request.body.size")
+}
+
+func TestStatusAndBytesWrittenFromContext(t *testing.T) {
+	// the status only writer used when the size isn't measured must record
+	// the response while the handler is running as well
+	testCases := []struct {
+		Name    string
+		Options []Option
+	}{
+		{
+			Name: "Default",
+		},
+		{
+			Name:    "Size not measured",
+			Options: []Option{WithMeasureSize(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var (
+				status         int
+				statusOK       bool
+				bytesWritten   int64
+				bytesWrittenOK bool
+				statusBefore   int
+				statusBeforeOK bool
+				statusInside   int
+				statusInsideOK bool
+			)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", testCase.Options...))
+			router.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					statusBefore, statusBeforeOK = StatusFromContext(r.Context())
+					defer func() {
+						status, statusOK = StatusFromContext(r.Context())
+						bytesWritten, bytesWrittenOK = BytesWrittenFromContext(r.Context())
+					}()
+					next.ServeHTTP(w, r)
+				})
+			})
+			router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusCreated)
+				statusInside, statusInsideOK = StatusFromContext(r.Context())
+				_, _ = w.Write([]byte("hello"))
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			assert.False(t, statusBeforeOK)
+			assert.Equal(t, 0, statusBefore)
+			assert.True(t, statusInsideOK)
+			assert.Equal(t, http.StatusCreated, statusInside)
+			assert.True(t, statusOK)
+			assert.Equal(t, http.StatusCreated, status)
+			assert.True(t, bytesWrittenOK)
+			assert.Equal(t, int64(5), bytesWritten)
+		})
+	}
+
+	_, ok := StatusFromContext(context.Background())
+	assert.False(t, ok)
+	_, ok = BytesWrittenFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestSDKIntegrationWithResponseContentLength(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		SemConv        SemConvStability
+		Handler        http.HandlerFunc
+		ExpectedLength int64
+	}{
+		{
+			Name: "Streamed response with multiple writes",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				for i := 0; i < 3; i++ {
+					_, _ = w.Write([]byte("chunk"))
+					w.(http.Flusher).Flush()
+				}
+			},
+			ExpectedLength: 15,
+		},
+		{
+			Name: "Content-Length header without write",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "42")
+				w.WriteHeader(http.StatusOK)
+			},
+			ExpectedLength: 42,
+		},
+		{
+			Name: "Empty response",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			ExpectedLength: 0,
+		},
+		{
+			Name:    "Stable semantic conventions",
+			SemConv: SemConvStabilityHTTP,
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("hello "))
+				_, _ = w.Write([]byte("world"))
+			},
+			ExpectedLength: 11,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					WithTracerProvider(provider),
+					WithSemConvStability(testCase.SemConv),
+					WithResponseContentLengthAttribute(true),
+				),
+			)
+			router.HandleFunc("/stream", testCase.Handler)
+
+			r0 := httptest.NewRequest("GET", "/stream", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			if testCase.SemConv == SemConvStabilityHTTP {
+				assertSpan(t, sr.Ended()[0],
+					"/stream",
+					trace.SpanKindServer,
+					attribute.Int64("http.response.body.size", testCase.ExpectedLength),
+				)
+				assertSpanNoAttributes(t, sr.Ended()[0], "http.response_content_length")
+				return
+			}
+			assertSpan(t, sr.Ended()[0],
+				"/stream",
+				trace.SpanKindServer,
+				attribute.Int64("http.response_content_length", testCase.ExpectedLength),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithResponseContentLengthDisabled(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithResponseContentLengthAttribute(false),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpanNoAttributes(t, sr.Ended()[0],
+		"http.response_content_length",
+		"http.response.body.size",
+	)
+}
+
+func TestSDKIntegrationWithCookieCountAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithCookieCountAttribute(true),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Add("Cookie", "a=1; b=2")
+	r0.AddCookie(&http.Cookie{Name: "c", Value: "3"})
+	r1 := httptest.NewRequest("GET", "/user/456", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.Int("http.request.cookie_count", 3),
+	)
+	assertSpan(t, sr.Ended()[1],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.Int("http.request.cookie_count", 0),
+	)
+}
+
+func TestSDKIntegrationWithoutCookieCountAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithTracerProvider(provider)))
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Add("Cookie", "a=1; b=2")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpanNoAttributes(t, sr.Ended()[0], "http.request.cookie_count")
+}
+
+func TestSDKIntegrationWithClientIPFromHeaders(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Options    []Option
+		RemoteAddr string
+		Headers    map[string]string
+		ExpectedIP string
+	}{
+		{
+			Name:       "First public address from X-Forwarded-For",
+			Options:    []Option{WithClientIPFromHeaders("X-Forwarded-For")},
+			Headers:    map[string]string{"X-Forwarded-For": "10.0.0.1, 203.0.113.7, 198.51.100.1"},
+			ExpectedIP: "203.0.113.7",
+		},
+		{
+			Name:       "Address with port",
+			Options:    []Option{WithClientIPFromHeaders("X-Forwarded-For")},
+			Headers:    map[string]string{"X-Forwarded-For": "203.0.113.7:4711"},
+			ExpectedIP: "203.0.113.7",
+		},
+		{
+			Name:       "IPv6 address with brackets",
+			Options:    []Option{WithClientIPFromHeaders("X-Forwarded-For")},
+			Headers:    map[string]string{"X-Forwarded-For": "[2001:db8::1]:443"},
+			ExpectedIP: "2001:db8::1",
+		},
+		{
+			Name:    "Headers are checked in order",
+			Options: []Option{WithClientIPFromHeaders("True-Client-IP", "X-Forwarded-For")},
+			Headers: map[string]string{
+				"X-Forwarded-For": "198.51.100.1",
+				"True-Client-IP":  "203.0.113.7",
+			},
+			ExpectedIP: "203.0.113.7",
+		},
+		{
+			Name:       "Malformed value fallback to remote address",
+			Options:    []Option{WithClientIPFromHeaders("True-Client-IP")},
+			Headers:    map[string]string{"True-Client-IP": "unknown"},
+			ExpectedIP: "192.0.2.1",
+		},
+		{
+			Name: "Trusted proxy",
+			Options: []Option{
+				WithClientIPFromHeaders("True-Client-IP"),
+				WithTrustedProxies("192.0.2.0/24"),
+			},
+			Headers:    map[string]string{"True-Client-IP": "203.0.113.7"},
+			ExpectedIP: "203.0.113.7",
+		},
+		{
+			Name: "Untrusted proxy",
+			Options: []Option{
+				WithClientIPFromHeaders("True-Client-IP", "X-Forwarded-For"),
+				WithTrustedProxies("10.0.0.0/8"),
+			},
+			Headers: map[string]string{
+				"True-Client-IP":  "203.0.113.7",
+				"X-Forwarded-For": "203.0.113.7",
+			},
+			ExpectedIP: "192.0.2.1",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.RemoteAddr = "192.0.2.1:1234"
+			for key, value := range testCase.Headers {
+				r0.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.client_ip", testCase.ExpectedIP),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithClientIPFromHeadersStable(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithSemConvStability(SemConvStabilityHTTP),
+			WithClientIPFromHeaders("True-Client-IP"),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.RemoteAddr = "192.0.2.1:1234"
+	r0.Header.Set("True-Client-IP", "203.0.113.7")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("client.address", "203.0.113.7"),
+		attribute.String("network.peer.address", "192.0.2.1"),
+	)
+}
+
+func TestSDKIntegrationWithForwardedHeader(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Options    []Option
+		Headers    map[string]string
+		Expected   []attribute.KeyValue
+		Unexpected []attribute.Key
+	}{
+		{
+			Name: "First element",
+			Headers: map[string]string{
+				"Forwarded": "for=203.0.113.7;proto=https;host=api.example.com, for=10.0.0.1",
+			},
+			Expected: []attribute.KeyValue{
+				attribute.String("http.client_ip", "203.0.113.7"),
+				attribute.String("http.scheme", "https"),
+				attribute.String("http.host", "api.example.com"),
+			},
+		},
+		{
+			Name: "Quoted IPv6 address with port",
+			Headers: map[string]string{
+				"Forwarded": `For="[2001:db8:cafe::17]:4711";Proto=HTTPS`,
+			},
+			Expected: []attribute.KeyValue{
+				attribute.String("http.client_ip", "2001:db8:cafe::17"),
+				attribute.String("http.scheme", "https"),
+			},
+		},
+		{
+			Name: "Obfuscated identifier",
+			Headers: map[string]string{
+				"Forwarded": "for=_hidden;proto=https, for=203.0.113.7",
+			},
+			Expected: []attribute.KeyValue{
+				attribute.String("http.scheme", "https"),
+			},
+			Unexpected: []attribute.Key{"http.client_ip"},
+		},
+		{
+			Name: "Forwarded wins over X-Forwarded-For",
+			Options: []Option{
+				WithClientIPFromHeaders("X-Forwarded-For"),
+			},
+			Headers: map[string]string{
+				"Forwarded":       "for=203.0.113.7",
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			Expected: []attribute.KeyValue{
+				attribute.String("http.client_ip", "203.0.113.7"),
+			},
+		},
+		{
+			Name: "Untrusted proxy",
+			Options: []Option{
+				WithTrustedProxies("10.0.0.0/8"),
+			},
+			Headers: map[string]string{
+				"Forwarded": "for=203.0.113.7;proto=https",
+			},
+			Expected: []attribute.KeyValue{
+				attribute.String("http.scheme", "http"),
+			},
+			Unexpected: []attribute.Key{"http.client_ip"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithForwardedHeader(true),
+			)...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.RemoteAddr = "192.0.2.1:1234"
+			for key, value := range testCase.Headers {
+				r0.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, testCase.Expected...)
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.Unexpected...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithForwardedHeaderStable(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithSemConvStability(SemConvStabilityHTTP),
+			WithForwardedHeader(true),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("Forwarded", `for="203.0.113.7:4711";proto=https;host="api.example.com:8443"`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("client.address", "203.0.113.7"),
+		attribute.String("url.scheme", "https"),
+		attribute.String("server.address", "api.example.com"),
+		attribute.Int("server.port", 8443),
+	)
+}
+
+type countingTracerProvider struct {
+	trace.TracerProvider
+	calls int32
+}
+
+func (p *countingTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	atomic.AddInt32(&p.calls, 1)
+	return p.TracerProvider.Tracer(name, opts...)
+}
+
+func TestSDKIntegrationWithTracerProviderSelector(t *testing.T) {
+	srDefault := tracetest.NewSpanRecorder()
+	defaultProvider := sdktrace.NewTracerProvider()
+	defaultProvider.RegisterSpanProcessor(srDefault)
+
+	srA := tracetest.NewSpanRecorder()
+	sdkProviderA := sdktrace.NewTracerProvider()
+	sdkProviderA.RegisterSpanProcessor(srA)
+	providerA := &countingTracerProvider{TracerProvider: sdkProviderA}
+
+	srB := tracetest.NewSpanRecorder()
+	sdkProviderB := sdktrace.NewTracerProvider()
+	sdkProviderB.RegisterSpanProcessor(srB)
+	providerB := &countingTracerProvider{TracerProvider: sdkProviderB}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(defaultProvider),
+			WithTracerProviderSelector(func(r *http.Request) trace.TracerProvider {
+				switch r.Header.Get("X-Tenant") {
+				case "a":
+					return providerA
+				case "b":
+					return providerB
+				}
+				return nil
+			}),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	for _, tenant := range []string{"a", "b", "a", "", "a"} {
+		r0 := httptest.NewRequest("GET", "/user/123", nil)
+		r0.Header.Set("X-Tenant", tenant)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r0)
+	}
+
+	assert.Len(t, srA.Ended(), 3)
+	assert.Len(t, srB.Ended(), 1)
+	assert.Len(t, srDefault.Ended(), 1)
+
+	// tracers are cached per provider
+	assert.Equal(t, int32(1), atomic.LoadInt32(&providerA.calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&providerB.calls))
+}
+
+func TestSDKIntegrationWithSchemeFromProxyHeaders(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		Headers        map[string]string
+		ExpectedScheme string
+	}{
+		{
+			Name:           "X-Forwarded-Proto",
+			Headers:        map[string]string{"X-Forwarded-Proto": "HTTPS"},
+			ExpectedScheme: "https",
+		},
+		{
+			Name:           "Forwarded wins over X-Forwarded-Proto",
+			Headers:        map[string]string{"Forwarded": "for=203.0.113.7;proto=https", "X-Forwarded-Proto": "http"},
+			ExpectedScheme: "https",
+		},
+		{
+			Name:           "Unknown value fallback to TLS detection",
+			Headers:        map[string]string{"X-Forwarded-Proto": "gopher"},
+			ExpectedScheme: "http",
+		},
+		{
+			Name:           "Missing headers",
+			ExpectedScheme: "http",
+		},
+		{
+			// public endpoint, the headers may be forged by the client
+			Name:           "Untrusted client",
+			Options:        []Option{WithTrustedProxies("10.0.0.0/8")},
+			Headers:        map[string]string{"X-Forwarded-Proto": "https"},
+			ExpectedScheme: "http",
+		},
+		{
+			Name:           "Trusted proxy",
+			Options:        []Option{WithTrustedProxies("192.0.2.0/24")},
+			Headers:        map[string]string{"X-Forwarded-Proto": "https"},
+			ExpectedScheme: "https",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithSchemeFromProxyHeaders(true),
+			)...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.RemoteAddr = "192.0.2.1:1234"
+			for key, value := range testCase.Headers {
+				r0.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.scheme", testCase.ExpectedScheme),
+			)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.String("scheme", testCase.ExpectedScheme))
+		})
+	}
+}
+
+func TestSDKIntegrationWithSchemeFromProxyHeadersStable(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithMeterProvider(meterProvider),
+			WithSemConvStability(SemConvStabilityHTTP),
+			WithSchemeFromProxyHeaders(true),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("url.scheme", "https"),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[0], "http.scheme")
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.String("url.scheme", "https"))
+}
+
+func TestSDKIntegrationWithRouteFilter(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	var patterns []string
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithChiRoutes(router),
+			WithFilter(func(r *http.Request) bool {
+				return r.Header.Get("X-Skip") == ""
+			}),
+			WithRouteFilter(func(routePattern string, r *http.Request) bool {
+				patterns = append(patterns, routePattern)
+				return routePattern != "/healthz"
+			}),
+		),
+	)
+	router.HandleFunc("/healthz", ok)
+	router.HandleFunc("/user/{id}", ok)
+
+	reqs := []*http.Request{
+		httptest.NewRequest("GET", "/healthz", nil),
+		httptest.NewRequest("GET", "/user/123", nil),
+		httptest.NewRequest("GET", "/user/456", nil),
+	}
+	reqs[2].Header.Set("X-Skip", "1")
+	for _, r := range reqs {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// the route filter is not called when the filter already skips
+	assert.Equal(t, []string{"/healthz", "/user/{id}"}, patterns)
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer)
+}
+
+func TestSDKIntegrationWithRouteFilterWithoutChiRoutes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	var patterns []string
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithRouteFilter(func(routePattern string, r *http.Request) bool {
+				patterns = append(patterns, routePattern)
+				return r.URL.Path != "/healthz"
+			}),
+		),
+	)
+	router.HandleFunc("/healthz", ok)
+	router.HandleFunc("/user/{id}", ok)
+
+	for _, target := range []string{"/healthz", "/user/123"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	}
+
+	assert.Equal(t, []string{"", ""}, patterns)
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer)
+}
+
+func TestSDKIntegrationWithSkipMethods(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithMeterProvider(meterProvider),
+			WithSkipMethods("options", "HEAD"),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	for _, method := range []string{"OPTIONS", "HEAD", "GET"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/user/123", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("http.method", "GET"),
+	)
+	assert.Len(t, meterProvider.Measurements("request_duration_seconds"), 1)
+	assert.Len(t, meterProvider.Measurements("requests_inflight"), 2)
+}
+
+func TestSDKIntegrationWithDeploymentSlotAttribute(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	canary := chi.NewRouter()
+	canary.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithDeploymentSlotAttribute("canary"),
+		),
+	)
+	canary.HandleFunc("/user/{id}", ok)
+
+	stable := chi.NewRouter()
+	stable.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithDeploymentSlotAttribute("stable"),
+		),
+	)
+	stable.HandleFunc("/user/{id}", ok)
+
+	untagged := chi.NewRouter()
+	untagged.Use(Middleware("foobar", WithTracerProvider(provider)))
+	untagged.HandleFunc("/user/{id}", ok)
+
+	for _, router := range []http.Handler{canary, stable, untagged} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/user/123", nil))
+	}
+
+	require.Len(t, sr.Ended(), 3)
+	assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, attribute.String("deployment.slot", "canary"))
+	assertSpan(t, sr.Ended()[1], "/user/{id}", trace.SpanKindServer, attribute.String("deployment.slot", "stable"))
+	assertSpanNoAttributes(t, sr.Ended()[2], "deployment.slot")
+}
+
+func TestSDKIntegrationWithProtocolVersion(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		HTTP2           bool
+		ExpectedVersion string
+	}{
+		{
+			Name:            "HTTP/1.1",
+			ExpectedVersion: "1.1",
+		},
+		{
+			Name:            "HTTP/2",
+			HTTP2:           true,
+			ExpectedVersion: "2",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					WithTracerProvider(provider),
+					WithMeterProvider(meterProvider),
+					WithSemConvStability(SemConvStabilityHTTPDup),
+					WithProtocolVersionMetricAttribute(true),
+				),
+			)
+			router.HandleFunc("/user/{id}", ok)
+
+			srv := httptest.NewUnstartedServer(router)
+			srv.EnableHTTP2 = testCase.HTTP2
+			srv.StartTLS()
+			defer srv.Close()
+
+			resp, err := srv.Client().Get(srv.URL + "/user/123")
+			require.NoError(t, err)
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.flavor", testCase.ExpectedVersion),
+				attribute.String("network.protocol.version", testCase.ExpectedVersion),
+			)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0],
+				attribute.String("flavor", testCase.ExpectedVersion),
+				attribute.String("network.protocol.version", testCase.ExpectedVersion),
+			)
+		})
+	}
+}
+
+func TestMetricsWithoutProtocolVersionMetricAttribute(t *testing.T) {
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithMeterProvider(meterProvider)))
+	router.HandleFunc("/user/{id}", ok)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/user/123", nil))
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assert.False(t, durations[0].attrs.HasValue("flavor"))
+}
+
+func TestSDKIntegrationWithoutResponseWriterPool(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	var ctx context.Context
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithResponseWriterPool(false),
+		),
+	)
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/user/123", nil))
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusAccepted),
+	)
+
+	// the writer is not reused, so the context is still valid
+	status, ok := StatusFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusAccepted, status)
+}
+
+func TestSDKIntegrationWithTLSAttributes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithTLSAttributes(true),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/user/123")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// plain HTTP request
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/user/123", nil))
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("tls.protocol.name", "tls"),
+		attribute.String("tls.protocol.version", "1.2"),
+		attribute.String("tls.cipher", tls.CipherSuiteName(resp.TLS.CipherSuite)),
+		attribute.Bool("tls.client.certificate_presented", false),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[1],
+		"tls.protocol.name",
+		"tls.protocol.version",
+		"tls.cipher",
+		"tls.client.certificate_presented",
+	)
+}
+
+func TestSDKIntegrationWithClientIPResolver(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithSemConvStability(SemConvStabilityHTTPDup),
+			WithClientIPFromHeaders("X-Forwarded-For"),
+			WithClientIPResolver(func(r *http.Request) string {
+				return r.Header.Get("X-Real-IP")
+			}),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("X-Real-IP", "203.0.113.7")
+	r0.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r1 := httptest.NewRequest("GET", "/user/123", nil)
+	r1.Header.Set("X-Forwarded-For", "198.51.100.1")
+	for _, r := range []*http.Request{r0, r1} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+	}
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("http.client_ip", "203.0.113.7"),
+		attribute.String("client.address", "203.0.113.7"),
+	)
+	// fallback when the resolver returns an empty string
+	assertSpan(t, sr.Ended()[1],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("http.client_ip", "198.51.100.1"),
+		attribute.String("client.address", "198.51.100.1"),
+	)
+}
+
+func TestSDKIntegrationWithUserAgentAttribute(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Options    []Option
+		UserAgent  string
+		Expected   []attribute.KeyValue
+		Unexpected []attribute.Key
+	}{
+		{
+			Name:      "Default",
+			UserAgent: "curl/8.0.1",
+			Expected: []attribute.KeyValue{
+				attribute.String("user_agent.original", "curl/8.0.1"),
+				attribute.String("http.user_agent", "curl/8.0.1"),
+			},
+		},
+		{
+			Name:       "Empty user agent",
+			Unexpected: []attribute.Key{"user_agent.original", "http.user_agent"},
+		},
+		{
+			Name:       "Disabled",
+			Options:    []Option{WithUserAgentAttribute(false)},
+			UserAgent:  "curl/8.0.1",
+			Unexpected: []attribute.Key{"user_agent.original", "http.user_agent"},
+		},
+		{
+			Name:      "Truncated",
+			Options:   []Option{WithUserAgentMaxLength(8)},
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64)",
+			Expected: []attribute.KeyValue{
+				attribute.String("user_agent.original", "Mozilla/"),
+				attribute.String("http.user_agent", "Mozilla/"),
+			},
+		},
+		{
+			Name:      "Truncated without splitting characters",
+			Options:   []Option{WithUserAgentMaxLength(5)},
+			UserAgent: "agenté",
+			Expected: []attribute.KeyValue{
+				attribute.String("user_agent.original", "agent"),
+			},
+		},
+		{
+			Name:      "Truncated in the middle of a character",
+			Options:   []Option{WithUserAgentMaxLength(6)},
+			UserAgent: "agenté",
+			Expected: []attribute.KeyValue{
+				attribute.String("user_agent.original", "agent"),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.Header.Set("User-Agent", testCase.UserAgent)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, testCase.Expected...)
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.Unexpected...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithTimeToFirstByteEvent(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithTimeToFirstByteEvent(true),
+		),
+	)
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("first"))
+		_, _ = w.Write([]byte("second"))
+	})
+	router.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, target := range []string{"/slow", "/empty"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	}
+
+	require.Len(t, sr.Ended(), 2)
+	span := sr.Ended()[0]
+	require.Len(t, span.Events(), 1)
+	event := span.Events()[0]
+	assert.Equal(t, "http.first_write", event.Name)
+	require.Len(t, event.Attributes, 1)
+	assert.Equal(t, attribute.Key("http.first_write.elapsed_ms"), event.Attributes[0].Key)
+	assert.GreaterOrEqual(t, event.Attributes[0].Value.AsFloat64(), float64(5))
+	assert.InDelta(t,
+		float64(event.Time.Sub(span.StartTime()))/float64(time.Millisecond),
+		event.Attributes[0].Value.AsFloat64(),
+		0.001,
+	)
+
+	// nothing written by the handler
+	assert.Len(t, sr.Ended()[1].Events(), 0)
+}
+
+func TestSDKIntegrationWithBodySizeAttributes(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Options    []Option
+		Expected   []attribute.KeyValue
+		Unexpected []attribute.Key
+	}{
+		{
+			Name:    "Active",
+			Options: []Option{WithBodySizeAttributes(true)},
+			Expected: []attribute.KeyValue{
+				attribute.Int64("http.request_content_length", 5),
+				attribute.Int64("http.response_content_length", 5),
+			},
+		},
+		{
+			Name:       "Inactive",
+			Options:    []Option{WithBodySizeAttributes(false)},
+			Unexpected: []attribute.Key{"http.request_content_length", "http.response_content_length"},
+		},
+		{
+			// the request content length is recorded by default
+			Name:       "Default",
+			Expected:   []attribute.KeyValue{attribute.Int64("http.request_content_length", 5)},
+			Unexpected: []attribute.Key{"http.response_content_length"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					append(testCase.Options, WithTracerProvider(provider))...,
+				),
+			)
+			router.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(w, r.Body)
+			})
+
+			r0 := httptest.NewRequest("POST", "/echo", strings.NewReader("hello"))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/echo", trace.SpanKindServer, testCase.Expected...)
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.Unexpected...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithPeerServiceFromHeader(t *testing.T) {
+	testCases := []struct {
+		Name                string
+		Options             []Option
+		HeaderValue         string
+		ExpectedPeerService string
+		ExpectedInMetrics   bool
+	}{
+		{
+			Name:                "Span only",
+			HeaderValue:         "billing",
+			ExpectedPeerService: "billing",
+		},
+		{
+			Name:                "Span & metrics",
+			Options:             []Option{WithPeerServiceMetricAttribute(true)},
+			HeaderValue:         "billing",
+			ExpectedPeerService: "billing",
+			ExpectedInMetrics:   true,
+		},
+		{
+			Name:                "Length limited",
+			HeaderValue:         strings.Repeat("a", 100),
+			ExpectedPeerService: strings.Repeat("a", 64),
+		},
+		{
+			Name:    "Missing header",
+			Options: []Option{WithPeerServiceMetricAttribute(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithPeerServiceFromHeader("X-Calling-Service"),
+			)...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			if testCase.HeaderValue != "" {
+				r0.Header.Set("X-Calling-Service", testCase.HeaderValue)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			if testCase.ExpectedPeerService == "" {
+				assertSpanNoAttributes(t, sr.Ended()[0], "peer.service")
+			} else {
+				assertSpan(t, sr.Ended()[0],
+					"/user/{id}",
+					trace.SpanKindServer,
+					attribute.String("peer.service", testCase.ExpectedPeerService),
+				)
+			}
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			if testCase.ExpectedInMetrics {
+				assertMetricAttributes(t, durations[0], attribute.String("peer.service", testCase.ExpectedPeerService))
+			} else {
+				assert.False(t, durations[0].attrs.HasValue("peer.service"))
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithTracingDisabled(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithMeterProvider(meterProvider),
+			WithTracingDisabled(true),
+		),
+	)
+	var handlerSpan trace.Span
+	router.HandleFunc("/user/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanFromContext(r.Context())
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	assert.Len(t, sr.Ended(), 0)
+	assert.Len(t, sr.Started(), 0)
+	assert.False(t, handlerSpan.SpanContext().IsValid())
+	assert.Empty(t, w.Header().Get("X-Trace-ID"))
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0],
+		attribute.String("id", "/user/123"),
+		attribute.Int("code", http.StatusOK),
+	)
+	sizes := meterProvider.Measurements("response_size_bytes")
+	require.Len(t, sizes, 1)
+	assert.Equal(t, int64(5), sizes[0].value)
+	assert.Len(t, meterProvider.Measurements("requests_inflight"), 2)
+}
+
+func TestSDKIntegrationWithTracingDisabledAndChiRoutes(t *testing.T) {
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithMeterProvider(meterProvider),
+			WithChiRoutes(router),
+			WithTracingDisabled(true),
+		),
+	)
+	router.HandleFunc("/user/{id:[0-9]+}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.String("id", "/user/{id:[0-9]+}"))
+}
+
+func TestSDKIntegrationWithEndUserExtractor(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	var calls []string
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithFilter(func(r *http.Request) bool {
+				return r.URL.Path != "/healthz"
+			}),
+			WithEndUserExtractor(func(r *http.Request) (string, string, bool) {
+				calls = append(calls, r.URL.Path)
+				token := r.Header.Get("X-Token")
+				if token == "" {
+					return "", "", false
+				}
+				return "user-" + token, "admin", true
+			}),
+		),
+	)
+	router.HandleFunc("/healthz", ok)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("X-Token", "42")
+	r1 := httptest.NewRequest("GET", "/user/123", nil)
+	r1.SetBasicAuth("basic-user", "secret")
+	r2 := httptest.NewRequest("GET", "/healthz", nil)
+	for _, r := range []*http.Request{r0, r1, r2} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+	}
+
+	// not called for the filtered request
+	assert.Equal(t, []string{"/user/123", "/user/123"}, calls)
+
+	require.Len(t, sr.Ended(), 2)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("enduser.id", "user-42"),
+		attribute.String("enduser.role", "admin"),
+	)
+	// ok=false leaves the span untouched
+	assertSpan(t, sr.Ended()[1],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("enduser.id", "basic-user"),
+	)
+	assertSpanNoAttributes(t, sr.Ended()[1], "enduser.role")
+}
+
+func TestSDKIntegrationWithBaggageAttributes(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Keys       []string
+		Expected   []attribute.KeyValue
+		Unexpected []attribute.Key
+	}{
+		{
+			Name: "Selected members",
+			Keys: []string{"tenant.id", "request.origin", "missing"},
+			Expected: []attribute.KeyValue{
+				attribute.String("tenant.id", "acme"),
+				attribute.String("request.origin", strings.Repeat("a", 256)),
+			},
+			Unexpected: []attribute.Key{"missing", "other", "baggage.tenant.id"},
+		},
+		{
+			Name: "All members",
+			Expected: []attribute.KeyValue{
+				attribute.String("baggage.tenant.id", "acme"),
+				attribute.String("baggage.request.origin", strings.Repeat("a", 256)),
+				attribute.String("baggage.other", "value"),
+			},
+			Unexpected: []attribute.Key{"tenant.id"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					WithTracerProvider(provider),
+					WithPropagators(propagation.NewCompositeTextMapPropagator(
+						propagation.TraceContext{},
+						propagation.Baggage{},
+					)),
+					WithBaggageAttributes(testCase.Keys...),
+				),
+			)
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.Header.Set("Baggage", "tenant.id=acme,request.origin="+strings.Repeat("a", 300)+",other=value")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, testCase.Expected...)
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.Unexpected...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithRequestIDAttribute(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		RequestIDLast bool
+		Header        string
+		Expected      string
+		ExpectedChi   bool
+	}{
+		{
+			Name:        "RequestID middleware before",
+			ExpectedChi: true,
+		},
+		{
+			Name:     "RequestID middleware before with incoming header",
+			Header:   "nginx-123",
+			Expected: "nginx-123",
+		},
+		{
+			Name:          "RequestID middleware after with incoming header",
+			RequestIDLast: true,
+			Header:        "nginx-123",
+			Expected:      "nginx-123",
+		},
+		{
+			// the generated id is only visible to the next handlers
+			Name:          "RequestID middleware after without header",
+			RequestIDLast: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			otelware := Middleware(
+				"foobar",
+				WithTracerProvider(provider),
+				WithRequestIDAttribute("X-Request-Id"),
+			)
+			router := chi.NewRouter()
+			if testCase.RequestIDLast {
+				router.Use(otelware, chimiddleware.RequestID)
+			} else {
+				router.Use(chimiddleware.RequestID, otelware)
+			}
+			var handlerReqID string
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				handlerReqID = chimiddleware.GetReqID(r.Context())
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			if testCase.Header != "" {
+				r0.Header.Set("X-Request-Id", testCase.Header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			expected := testCase.Expected
+			if testCase.ExpectedChi {
+				expected = handlerReqID
+				require.NotEmpty(t, expected)
+			}
+			require.Len(t, sr.Ended(), 1)
+			if expected == "" {
+				assertSpanNoAttributes(t, sr.Ended()[0], "http.request_id")
+				return
+			}
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.request_id", expected),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithServerAddressAttributes(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Host          string
+		LocalAddr     net.Addr
+		ExpectedAttrs []attribute.KeyValue
+	}{
+		{
+			Name: "Host with port",
+			Host: "example.com:8443",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("server.address", "example.com"),
+				attribute.Int("server.port", 8443),
+			},
+		},
+		{
+			Name: "Bare host",
+			Host: "example.com",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("server.address", "example.com"),
+			},
+		},
+		{
+			Name:      "Bare host with listener port",
+			Host:      "example.com",
+			LocalAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080},
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("server.address", "example.com"),
+				attribute.Int("server.port", 8080),
+			},
+		},
+		{
+			Name: "IPv6 literal with port",
+			Host: "[::1]:8443",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("server.address", "::1"),
+				attribute.Int("server.port", 8443),
+			},
+		},
+		{
+			Name: "IPv6 literal without port",
+			Host: "[::1]",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("server.address", "::1"),
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware(
+				"foobar",
+				WithTracerProvider(provider),
+				WithServerAddressAttributes(true),
+			))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.Host = testCase.Host
+			if testCase.LocalAddr != nil {
+				r0 = r0.WithContext(context.WithValue(r0.Context(), http.LocalAddrContextKey, testCase.LocalAddr))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assertSpan(t, span,
+				"/user/{id}",
+				trace.SpanKindServer,
+				append(testCase.ExpectedAttrs,
+					// the old semantic conventions attributes are kept
+					attribute.String("http.method", "GET"),
+					attribute.String("http.route", "/user/{id}"),
+				)...,
+			)
+			if len(testCase.ExpectedAttrs) == 1 {
+				assertSpanNoAttributes(t, span, "server.port")
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithoutServerAddressAttributes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithTracerProvider(provider)))
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Host = "example.com:8443"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpanNoAttributes(t, sr.Ended()[0], "server.address", "server.port")
+}
+
+func TestSDKIntegrationWithCacheStatusHeader(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Handler  http.HandlerFunc
+		Expected string
+	}{
+		{
+			Name: "Header is lowercased",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Cache", "MISS")
+				w.WriteHeader(http.StatusOK)
+			},
+			Expected: "miss",
+		},
+		{
+			Name: "Header set after the response is written",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Cache", "HIT")
+				w.WriteHeader(http.StatusOK)
+				// not sent to the client
+				w.Header().Set("X-Cache", "BYPASS")
+			},
+			Expected: "hit",
+		},
+		{
+			Name: "Response not written",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Cache", "Bypass")
+			},
+			Expected: "bypass",
+		},
+		{
+			Name:    "Header absent",
+			Handler: ok,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware(
+				"foobar",
+				WithTracerProvider(provider),
+				WithCacheStatusHeader("x-cache"),
+			))
+			router.HandleFunc("/user/{id}", testCase.Handler)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			if testCase.Expected == "" {
+				assertSpanNoAttributes(t, sr.Ended()[0], "http.cache.status")
+				return
+			}
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.cache.status", testCase.Expected),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithErrorStatusCodes(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		StatusCode     int
+		ExpectedStatus codes.Code
+	}{
+		{
+			Name:           "Listed status code",
+			Options:        []Option{WithErrorStatusCodes(http.StatusNotFound, http.StatusTooManyRequests)},
+			StatusCode:     http.StatusTooManyRequests,
+			ExpectedStatus: codes.Error,
+		},
+		{
+			Name:           "Not listed status code",
+			Options:        []Option{WithErrorStatusCodes(http.StatusNotFound, http.StatusTooManyRequests)},
+			StatusCode:     http.StatusOK,
+			ExpectedStatus: codes.Unset,
+		},
+		{
+			Name:           "Server error is kept",
+			Options:        []Option{WithErrorStatusCodes(http.StatusNotFound)},
+			StatusCode:     http.StatusInternalServerError,
+			ExpectedStatus: codes.Error,
+		},
+		{
+			Name:           "Redirect listed",
+			Options:        []Option{WithErrorStatusCodes(http.StatusFound)},
+			StatusCode:     http.StatusFound,
+			ExpectedStatus: codes.Error,
+		},
+		{
+			Name:           "Unset",
+			StatusCode:     http.StatusFound,
+			ExpectedStatus: codes.Unset,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.StatusCode)
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, testCase.ExpectedStatus, sr.Ended()[0].Status().Code)
+		})
+	}
+}
+
+func TestSDKIntegrationWithInjectResponseContext(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Options  []Option
+		Expected bool
+	}{
+		{
+			Name:     "Enabled",
+			Options:  []Option{WithInjectResponseContext(true)},
+			Expected: true,
+		},
+		{
+			Name: "Disabled by default",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithPropagators(propagation.TraceContext{}),
+			)...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte("ok"))
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			if !testCase.Expected {
+				assert.Empty(t, w.Result().Header.Get("traceparent"))
+				return
+			}
+			ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(w.Result().Header))
+			spanCtx := trace.SpanContextFromContext(ctx)
+			assert.Equal(t, sr.Ended()[0].SpanContext().TraceID(), spanCtx.TraceID())
+			assert.Equal(t, sr.Ended()[0].SpanContext().SpanID(), spanCtx.SpanID())
+			assert.True(t, spanCtx.IsSampled())
+		})
+	}
+}
+
+func TestSDKIntegrationWithRateLimitAttributes(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Options       []Option
+		StatusCode    int
+		ExpectedAttrs []attribute.KeyValue
+	}{
+		{
+			Name:       "Too many requests",
+			Options:    []Option{WithRateLimitAttributes(true)},
+			StatusCode: http.StatusTooManyRequests,
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.Int64("http.ratelimit.retry-after", 30),
+				attribute.Int64("http.ratelimit.x-ratelimit-limit", 100),
+				attribute.Int64("http.ratelimit.x-ratelimit-remaining", 0),
+				attribute.String("http.ratelimit.ratelimit-policy", "100;w=60"),
+			},
+		},
+		{
+			Name:       "Service unavailable",
+			Options:    []Option{WithRateLimitAttributes(true)},
+			StatusCode: http.StatusServiceUnavailable,
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.Int64("http.ratelimit.retry-after", 30),
+			},
+		},
+		{
+			Name:       "Always capture",
+			Options:    []Option{WithRateLimitAttributesAlways(true)},
+			StatusCode: http.StatusOK,
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.Int64("http.ratelimit.x-ratelimit-remaining", 0),
+			},
+		},
+		{
+			Name:       "Not captured for other status",
+			Options:    []Option{WithRateLimitAttributes(true)},
+			StatusCode: http.StatusOK,
+		},
+		{
+			Name:       "Disabled by default",
+			StatusCode: http.StatusTooManyRequests,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "30")
+				w.Header().Set("X-RateLimit-Limit", "100")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("RateLimit-Policy", "100;w=60")
+				w.WriteHeader(testCase.StatusCode)
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			if len(testCase.ExpectedAttrs) == 0 {
+				assertSpanNoAttributes(t, sr.Ended()[0],
+					"http.ratelimit.retry-after",
+					"http.ratelimit.x-ratelimit-remaining",
+				)
+				return
+			}
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, testCase.ExpectedAttrs...)
+		})
+	}
+}
+
+func TestRateLimitAttributesNonNumeric(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "Wed, 21 Oct 2015 07:28:00 GMT")
+	header.Set("RateLimit-Remaining", " 42 ")
+
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("http.ratelimit.retry-after", "Wed, 21 Oct 2015 07:28:00 GMT"),
+		attribute.Int64("http.ratelimit.ratelimit-remaining", 42),
+	}, rateLimitAttributes(header))
+}
+
+func TestSDKIntegrationWithBaggageAttributesWithoutBaggagePropagator(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	// the requirement of the baggage propagator is not enforced, nothing is
+	// recorded without it
+	router := chi.NewRouter()
+	router.Use(
+		Middleware(
+			"foobar",
+			WithTracerProvider(provider),
+			WithPropagators(propagation.TraceContext{}),
+			WithBaggageAttributes("tenant.id"),
+		),
+	)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	r0.Header.Set("Baggage", "tenant.id=acme")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpanNoAttributes(t, sr.Ended()[0], "tenant.id", "baggage.tenant.id")
+}
+
+func TestSDKIntegrationWithContentTypeAttributes(t *testing.T) {
+	testCases := []struct {
+		Name               string
+		Options            []Option
+		RequestContentType string
+		ExpectedAttrs      []attribute.KeyValue
+		UnexpectedAttrs    []attribute.Key
+	}{
+		{
+			Name: "Both enabled",
+			Options: []Option{
+				WithRequestContentTypeAttribute(true),
+				WithResponseContentTypeAttribute(true),
+			},
+			RequestContentType: "application/json; charset=utf-8",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("http.request.content_type", "application/json"),
+				attribute.String("http.response.content_type", "application/x-protobuf"),
+			},
+		},
+		{
+			Name:               "Malformed request content type",
+			Options:            []Option{WithRequestContentTypeAttribute(true)},
+			RequestContentType: "application/json; " + strings.Repeat("=", 100),
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("http.request.content_type", ("application/json; " + strings.Repeat("=", 100))[:64]),
+			},
+			UnexpectedAttrs: []attribute.Key{"http.response.content_type"},
+		},
+		{
+			Name:            "Only response",
+			Options:         []Option{WithResponseContentTypeAttribute(true)},
+			ExpectedAttrs:   []attribute.KeyValue{attribute.String("http.response.content_type", "application/x-protobuf")},
+			UnexpectedAttrs: []attribute.Key{"http.request.content_type"},
+		},
+		{
+			Name:               "Disabled by default",
+			RequestContentType: "application/json",
+			UnexpectedAttrs:    []attribute.Key{"http.request.content_type", "http.response.content_type"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r0 := httptest.NewRequest("POST", "/user/123", nil)
+			if testCase.RequestContentType != "" {
+				r0.Header.Set("Content-Type", testCase.RequestContentType)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, testCase.ExpectedAttrs...)
+			assertSpanNoAttributes(t, sr.Ended()[0], testCase.UnexpectedAttrs...)
+		})
+	}
+}
+
+func TestSDKIntegrationWithAttributeValueLimit(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithAttributeValueLimit(16),
+		WithRequestHeaderAttributes("X-Long"),
+		WithResponseHeaderAttributes("X-Short"),
+	))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// attributes set by the handler are not limited
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("handler.value", strings.Repeat("h", 32)))
+		w.Header().Set("X-Short", "short")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123?q="+strings.Repeat("a", 100), nil)
+	r0.Header.Set("X-Long", strings.Repeat("b", 100))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assertSpan(t, span,
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.String("http.target", "/user/123?q=aaaa..."),
+		attribute.StringSlice("http.request.header.x-long", []string{strings.Repeat("b", 16) + "..."}),
+		attribute.StringSlice("http.response.header.x-short", []string{"short"}),
+		attribute.String("http.route", "/user/{id}"),
+		attribute.String("handler.value", strings.Repeat("h", 32)),
+	)
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+}
+
+func TestSDKIntegrationWithoutAttributeValueLimit(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithTracerProvider(provider)))
+	router.HandleFunc("/user/{id}", ok)
+
+	target := "/user/123?q=" + strings.Repeat("a", 100)
+	r0 := httptest.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, attribute.String("http.target", target))
+}
+
+func TestSDKIntegrationWithInstrumentationName(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Options         []Option
+		ExpectedName    string
+		ExpectedVersion string
+	}{
+		{
+			Name:            "Default",
+			ExpectedName:    "github.com/riandyrn/otelchi",
+			ExpectedVersion: contrib.Version(),
+		},
+		{
+			Name: "Custom",
+			Options: []Option{
+				WithInstrumentationName("example.com/wrapper"),
+				WithInstrumentationVersion("1.2.3"),
+			},
+			ExpectedName:    "example.com/wrapper",
+			ExpectedVersion: "1.2.3",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+			)...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			scope := sr.Ended()[0].InstrumentationScope()
+			assert.Equal(t, testCase.ExpectedName, scope.Name)
+			assert.Equal(t, testCase.ExpectedVersion, scope.Version)
+
+			assert.Equal(t, testCase.ExpectedName, meterProvider.meterName)
+			assert.Equal(t, testCase.ExpectedVersion, meterProvider.meterVersion)
+		})
+	}
+}
+
+func TestSDKIntegrationWithSampleDecision(t *testing.T) {
+	testCases := []struct {
+		Name            string
+		Path            string
+		Parent          bool
+		ExpectedSampled bool
+	}{
+		{
+			Name:            "Sampled",
+			Path:            "/user/123",
+			ExpectedSampled: true,
+		},
+		{
+			Name: "Not sampled",
+			Path: "/metrics",
+		},
+		{
+			Name:   "Not sampled with sampled parent",
+			Path:   "/metrics",
+			Parent: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())))
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+			propagator := propagation.TraceContext{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware(
+				"foobar",
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithPropagators(propagator),
+				WithSampleDecision(func(r *http.Request) bool {
+					return r.URL.Path != "/metrics"
+				}),
+			))
+			var handlerSpanCtx trace.SpanContext
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				handlerSpanCtx = trace.SpanContextFromContext(r.Context())
+				// the spans of the handler follow the decision
+				_, child := provider.Tracer("handler").Start(r.Context(), "child")
+				child.End()
+			}
+			router.HandleFunc("/user/{id}", handler)
+			router.HandleFunc("/metrics", handler)
+
+			r0 := httptest.NewRequest("GET", testCase.Path, nil)
+			if testCase.Parent {
+				propagator.Inject(trace.ContextWithRemoteSpanContext(context.Background(), sc), propagation.HeaderCarrier(r0.Header))
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			// the metrics are recorded anyway
+			assert.Len(t, meterProvider.Measurements("request_duration_seconds"), 1)
+
+			assert.True(t, handlerSpanCtx.IsValid())
+			assert.Equal(t, testCase.ExpectedSampled, handlerSpanCtx.IsSampled())
+			if testCase.ExpectedSampled {
+				assert.Len(t, sr.Ended(), 2)
+				return
+			}
+			assert.Len(t, sr.Ended(), 0)
+			if testCase.Parent {
+				assert.Equal(t, sc.TraceID(), handlerSpanCtx.TraceID())
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithHTTPTarget(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		Target         string
+		EmptyURI       bool
+		ExpectedTarget string
+	}{
+		{
+			Name:           "Encoded characters",
+			Options:        []Option{WithHTTPTarget(true)},
+			Target:         "/user/a%2Fb?q=caf%C3%A9&x=1",
+			ExpectedTarget: "/user/a%2Fb?q=caf%C3%A9&x=1",
+		},
+		{
+			Name:           "Empty query",
+			Options:        []Option{WithHTTPTarget(true)},
+			Target:         "/user/123?",
+			ExpectedTarget: "/user/123?",
+		},
+		{
+			Name:           "Stable semantic conventions only",
+			Options:        []Option{WithHTTPTarget(true), WithSemConvStability(SemConvStabilityHTTP)},
+			Target:         "/user/123?q=1",
+			ExpectedTarget: "/user/123?q=1",
+		},
+		{
+			Name:           "With query redaction",
+			Options:        []Option{WithHTTPTarget(true), WithQueryParamRedaction("token")},
+			Target:         "/user/123?token=secret&q=1",
+			ExpectedTarget: "/user/123?token=REDACTED&q=1",
+		},
+		{
+			Name:           "With query dropped",
+			Options:        []Option{WithHTTPTarget(true), WithDropQueryString(true)},
+			Target:         "/user/123?token=secret",
+			ExpectedTarget: "/user/123",
+		},
+		{
+			Name:           "Without original request URI",
+			Options:        []Option{WithHTTPTarget(true)},
+			Target:         "/user/a%2Fb?q=1",
+			EmptyURI:       true,
+			ExpectedTarget: "/user/a%2Fb?q=1",
+		},
+		{
+			Name:           "Disabled",
+			Target:         "/user/123?q=1",
+			ExpectedTarget: "/user/123?q=1",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", testCase.Target, nil)
+			if testCase.EmptyURI {
+				r0.RequestURI = ""
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer,
+				attribute.String("http.target", testCase.ExpectedTarget),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithPublicEndpoint(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		ExpectedPublic bool
+	}{
+		{
+			Name:           "Public endpoint",
+			Options:        []Option{WithPublicEndpoint(true)},
+			ExpectedPublic: true,
+		},
+		{
+			Name: "Public endpoint function",
+			Options: []Option{WithPublicEndpointFn(func(r *http.Request) bool {
+				return r.Header.Get("X-Internal") == ""
+			})},
+			ExpectedPublic: true,
+		},
+		{
+			Name: "Public endpoint function returns false",
+			Options: []Option{WithPublicEndpointFn(func(r *http.Request) bool {
+				return false
+			})},
+		},
+		{
+			Name:           "Public endpoint with attribute value limit",
+			Options:        []Option{WithPublicEndpoint(true), WithAttributeValueLimit(64)},
+			ExpectedPublic: true,
+		},
+		{
+			Name: "Disabled by default",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			propagator := propagation.TraceContext{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithPropagators(propagator),
+			)...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			propagator.Inject(trace.ContextWithRemoteSpanContext(context.Background(), sc), propagation.HeaderCarrier(r0.Header))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			if !testCase.ExpectedPublic {
+				assert.Equal(t, sc.TraceID(), span.SpanContext().TraceID())
+				assert.Equal(t, sc.SpanID(), span.Parent().SpanID())
+				assert.Empty(t, span.Links())
+				return
+			}
+			assert.NotEqual(t, sc.TraceID(), span.SpanContext().TraceID())
+			assert.False(t, span.Parent().IsValid())
+			require.Len(t, span.Links(), 1)
+			assert.Equal(t, sc.TraceID(), span.Links()[0].SpanContext.TraceID())
+			assert.Equal(t, sc.SpanID(), span.Links()[0].SpanContext.SpanID())
+		})
+	}
+}
+
+func TestSDKIntegrationWithSpanNameFormatter(t *testing.T) {
+	testCases := []struct {
+		Name     string
+		Options  func(router chi.Router) []Option
+		Path     string
+		Expected string
+	}{
+		{
+			Name:     "Route pattern resolved after the handler",
+			Options:  func(router chi.Router) []Option { return nil },
+			Path:     "/User/123",
+			Expected: "myservice:GET /user/{id}",
+		},
+		{
+			Name: "Pre-matched route pattern",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithChiRoutes(router)}
+			},
+			Path:     "/User/123",
+			Expected: "myservice:GET /user/{id}",
+		},
+		{
+			Name: "Request method option is bypassed",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithRequestMethodInSpanName(true)}
+			},
+			Path:     "/User/123",
+			Expected: "myservice:GET /user/{id}",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			// the formatter only gets the route pattern, never the raw path
+			var routePatterns []string
+			formatter := func(method, routePattern string, r *http.Request) string {
+				routePatterns = append(routePatterns, routePattern)
+				return "myservice:" + method + " " + strings.ToLower(routePattern)
+			}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options(router),
+				WithTracerProvider(provider),
+				WithSpanNameFormatter(formatter),
+			)...))
+			router.HandleFunc("/User/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", testCase.Path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, testCase.Expected, sr.Ended()[0].Name())
+			assert.Equal(t, []string{"/User/{id}"}, routePatterns)
+		})
+	}
+}
+
+func TestSDKIntegrationWithUnmatchedRoute(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Options      []Option
+		ExpectedName string
+	}{
+		{
+			Name:         "Default fallback",
+			ExpectedName: "unmatched",
+		},
+		{
+			Name:         "Custom fallback",
+			Options:      []Option{WithUnmatchedRouteName("not_found")},
+			ExpectedName: "not_found",
+		},
+		{
+			Name:         "Method in span name",
+			Options:      []Option{WithRequestMethodInSpanName(true)},
+			ExpectedName: "GET unmatched",
+		},
+		{
+			Name: "Span name formatter",
+			Options: []Option{WithSpanNameFormatter(func(method, routePattern string, r *http.Request) string {
+				return "HTTP " + method + " " + routePattern
+			})},
+			ExpectedName: "HTTP GET unmatched",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/wp-admin/setup.php", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Equal(t, testCase.ExpectedName, span.Name())
+			assertSpan(t, span,
+				testCase.ExpectedName,
+				trace.SpanKindServer,
+				attribute.String("http.target", "/wp-admin/setup.php"),
+				attribute.Int("http.status_code", http.StatusNotFound),
+			)
+			assertSpanNoAttributes(t, span, "http.route")
+		})
+	}
+}
+
+func TestSDKIntegrationWithMethodNotAllowed(t *testing.T) {
+	// the route exists for another method, so the request is not unmatched
+	testCases := []struct {
+		Name    string
+		Options func(router chi.Router) []Option
+	}{
+		{
+			Name:    "Default",
+			Options: func(router chi.Router) []Option { return nil },
+		},
+		{
+			Name: "With chi routes",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithChiRoutes(router)}
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options(router),
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithRouteMetricID(true),
+			)...))
+			router.Get("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("POST", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"/user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.route", "/user/{id}"),
+				attribute.Int("http.status_code", http.StatusMethodNotAllowed),
+			)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.String("id", "/user/{id}"))
+
+			// the route is only known once chi responded
+			for _, m := range meterProvider.Measurements("requests_inflight") {
+				assertMetricAttributes(t, m, attribute.String("id", ""))
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithServerNameResolver(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Host         string
+		ExpectedName string
+	}{
+		{
+			Name:         "Resolved from host",
+			Host:         "api.example.com",
+			ExpectedName: "api.example.com",
+		},
+		{
+			Name:         "Fallback to the static name",
+			Host:         "unknown.example.com",
+			ExpectedName: "foobar",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware(
+				"foobar",
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithServerNameResolver(func(r *http.Request) string {
+					if strings.HasPrefix(r.Host, "api.") {
+						return r.Host
+					}
+					return ""
+				}),
+			))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r0.Host = testCase.Host
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer,
+				attribute.String("http.server_name", testCase.ExpectedName),
+			)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.String("service", testCase.ExpectedName))
+		})
+	}
+}
+
+func TestSDKIntegrationWithQueryStringAttribute(t *testing.T) {
+	scrub := func(rawQuery string) string {
+		return strings.Replace(rawQuery, "secret", "xxx", -1)
+	}
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		Target         string
+		ExpectedAttrs  []attribute.KeyValue
+		UnexpectedAttr attribute.Key
+	}{
+		{
+			Name:    "Enabled",
+			Options: []Option{WithQueryStringAttribute(true)},
+			Target:  "/user/123?q=1&token=secret",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("url.query", "q=1&token=secret"),
+			},
+		},
+		{
+			Name:    "With query redactor",
+			Options: []Option{WithQueryStringAttribute(true), WithQueryRedactor(scrub)},
+			Target:  "/user/123?q=1&token=secret",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("url.query", "q=1&token=xxx"),
+				attribute.String("http.target", "/user/123?q=1&token=xxx"),
+			},
+		},
+		{
+			Name: "With query redactor and param redaction",
+			Options: []Option{
+				WithQueryStringAttribute(true),
+				WithQueryParamRedaction("q"),
+				WithQueryRedactor(scrub),
+			},
+			Target: "/user/123?q=1&token=secret",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("url.query", "q=REDACTED&token=xxx"),
+			},
+		},
+		{
+			Name: "Query removed by redactor",
+			Options: []Option{
+				WithQueryStringAttribute(true),
+				WithQueryRedactor(func(string) string { return "" }),
+			},
+			Target: "/user/123?q=1",
+			ExpectedAttrs: []attribute.KeyValue{
+				attribute.String("http.target", "/user/123"),
+			},
+			UnexpectedAttr: "url.query",
+		},
+		{
+			Name:           "Empty query",
+			Options:        []Option{WithQueryStringAttribute(true)},
+			Target:         "/user/123",
+			UnexpectedAttr: "url.query",
+		},
+		{
+			Name:           "Disabled by default",
+			Target:         "/user/123?q=1",
+			UnexpectedAttr: "url.query",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", testCase.Target, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0], "/user/{id}", trace.SpanKindServer, testCase.ExpectedAttrs...)
+			if testCase.UnexpectedAttr != "" {
+				assertSpanNoAttributes(t, sr.Ended()[0], testCase.UnexpectedAttr)
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithMountedSubrouters(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Path         string
+		ExpectedName string
+	}{
+		{
+			Name:         "Mount inside route group",
+			Path:         "/api/v1/users/123",
+			ExpectedName: "/api/v1/users/{id}",
+		},
+		{
+			Name:         "Three levels of mounts",
+			Path:         "/api/v1/admin/roles/42",
+			ExpectedName: "/api/v1/admin/roles/{id}",
+		},
+	}
+	for _, withChiRoutes := range []bool{false, true} {
+		for _, testCase := range testCases {
+			t.Run(fmt.Sprintf("%s/WithChiRoutes=%v", testCase.Name, withChiRoutes), func(t *testing.T) {
+				sr := tracetest.NewSpanRecorder()
+				provider := sdktrace.NewTracerProvider()
+				provider.RegisterSpanProcessor(sr)
+
+				roles := chi.NewRouter()
+				roles.Get("/{id}", ok)
+				admin := chi.NewRouter()
+				admin.Mount("/roles", roles)
+				users := chi.NewRouter()
+				users.Get("/{id}", ok)
+				v1 := chi.NewRouter()
+				v1.Mount("/users", users)
+				v1.Mount("/admin", admin)
+
+				router := chi.NewRouter()
+				opts := []Option{WithTracerProvider(provider)}
+				if withChiRoutes {
+					opts = append(opts, WithChiRoutes(router))
+				}
+				router.Use(Middleware("foobar", opts...))
+				router.Route("/api", func(r chi.Router) {
+					r.Mount("/v1", v1)
+				})
+
+				r0 := httptest.NewRequest("GET", testCase.Path, nil)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r0)
+
+				// the patterns of the mounted routers are joined without the
+				// intermediate wildcards
+				require.Len(t, sr.Ended(), 1)
+				assertSpan(t, sr.Ended()[0],
+					testCase.ExpectedName,
+					trace.SpanKindServer,
+					attribute.String("http.route", testCase.ExpectedName),
+				)
+			})
+		}
+	}
+}
+
+func TestSDKIntegrationWithTrimTrailingWildcard(t *testing.T) {
+	testCases := []struct {
+		Name          string
+		Options       func(router chi.Router) []Option
+		Path          string
+		ExpectedRoute string
+	}{
+		{
+			Name: "Wildcard route",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithTrimTrailingWildcard(true)}
+			},
+			Path:          "/static/css/main.css",
+			ExpectedRoute: "/static",
+		},
+		{
+			Name: "Wildcard route pre-matched",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithTrimTrailingWildcard(true), WithChiRoutes(router)}
+			},
+			Path:          "/static/css/main.css",
+			ExpectedRoute: "/static",
+		},
+		{
+			Name: "Root wildcard",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithTrimTrailingWildcard(true)}
+			},
+			Path:          "/index.html",
+			ExpectedRoute: "/",
+		},
+		{
+			Name: "Route without wildcard",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithTrimTrailingWildcard(true)}
+			},
+			Path:          "/user/123",
+			ExpectedRoute: "/user/{id}",
+		},
+		{
+			Name: "Disabled by default",
+			Options: func(router chi.Router) []Option {
+				return nil
+			},
+			Path:          "/static/css/main.css",
+			ExpectedRoute: "/static/*",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options(router),
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+			)...))
+			router.HandleFunc("/static/*", ok)
+			router.HandleFunc("/user/{id}", ok)
+			router.HandleFunc("/*", ok)
+
+			r0 := httptest.NewRequest("GET", testCase.Path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				testCase.ExpectedRoute,
+				trace.SpanKindServer,
+				attribute.String("http.route", testCase.ExpectedRoute),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithRouteParamsAttributes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithRouteParamsAttributes(true),
+	))
+	router.Route("/org/{id}", func(r chi.Router) {
+		r.HandleFunc("/post/{slug}/files/*", ok)
+	})
+
+	r0 := httptest.NewRequest("GET", "/org/acme/post/hello-world/files/a/b.txt", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/org/{id}/post/{slug}/files/*",
+		trace.SpanKindServer,
+		attribute.String("http.route.param.id", "acme"),
+		attribute.String("http.route.param.slug", "hello-world"),
+		attribute.String("http.route.param.*", "a/b.txt"),
+	)
+}
+
+func TestSDKIntegrationWithChiRoutesInline(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	// the middleware is used inline in a mounted sub-router, matching the
+	// full path against the sub-router would fail
+	users := chi.NewRouter()
+	var nameInHandler string
+	users.With(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithChiRoutes(users),
+	)).Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		nameInHandler = trace.SpanFromContext(r.Context()).(sdktrace.ReadOnlySpan).Name()
+	})
+	router := chi.NewRouter()
+	router.Mount("/api", users)
+
+	r0 := httptest.NewRequest("GET", "/api/users/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// the pattern of the existing route context is known before the
+	// handler is executed
+	assert.Equal(t, "/api/users/{id}", nameInHandler)
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/api/users/{id}",
+		trace.SpanKindServer,
+		attribute.String("http.route", "/api/users/{id}"),
+	)
+}
+
+func TestSDKIntegrationWithSetSpanName(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Options func(router chi.Router) []Option
+		Path    string
+	}{
+		{
+			Name:    "Without chi routes",
+			Options: func(router chi.Router) []Option { return nil },
+			Path:    "/user/123",
+		},
+		{
+			Name: "With chi routes",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithChiRoutes(router)}
+			},
+			Path: "/user/123",
+		},
+		{
+			Name:    "Unmatched route",
+			Options: func(router chi.Router) []Option { return nil },
+			Path:    "/unknown",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options(router), WithTracerProvider(provider))...))
+			setName := func(w http.ResponseWriter, r *http.Request) {
+				SetSpanName(r.Context(), "custom name")
+			}
+			router.HandleFunc("/user/{id}", setName)
+			router.NotFound(setName)
+
+			r0 := httptest.NewRequest("GET", testCase.Path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, "custom name", sr.Ended()[0].Name())
+		})
+	}
+}
+
+func TestSDKIntegrationWithChiRoutesInMountedRouter(t *testing.T) {
+	testCases := []struct {
+		Name                  string
+		Routes                func(root, api chi.Router) chi.Routes
+		ExpectedNameInHandler string
+	}{
+		{
+			Name:                  "Routes of the sub-router",
+			Routes:                func(root, api chi.Router) chi.Routes { return api },
+			ExpectedNameInHandler: "/api/users/{id}",
+		},
+		{
+			Name:                  "Routes of the root router",
+			Routes:                func(root, api chi.Router) chi.Routes { return root },
+			ExpectedNameInHandler: "/api/users/{id}",
+		},
+		{
+			Name:   "Without chi routes",
+			Routes: func(root, api chi.Router) chi.Routes { return nil },
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			api := chi.NewRouter()
+			var nameInHandler string
+			opts := []Option{WithTracerProvider(provider)}
+			if routes := testCase.Routes(router, api); routes != nil {
+				opts = append(opts, WithChiRoutes(routes))
+			}
+			api.Use(Middleware("foobar", opts...))
+			api.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+				nameInHandler = trace.SpanFromContext(r.Context()).(sdktrace.ReadOnlySpan).Name()
+			})
+			router.Mount("/api", api)
+
+			r0 := httptest.NewRequest("GET", "/api/users/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			// the mount prefix is part of the pre-matched pattern as well as
+			// the pattern resolved after the handler
+			assert.Equal(t, testCase.ExpectedNameInHandler, nameInHandler)
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"/api/users/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.route", "/api/users/{id}"),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithServerNameInSpanName(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		Options      func(router chi.Router) []Option
+		Path         string
+		ExpectedName string
+	}{
+		{
+			Name: "Route resolved after the handler",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithServerNameInSpanName(true)}
+			},
+			Path:         "/charges/123",
+			ExpectedName: "payments-api: /charges/{id}",
+		},
+		{
+			Name: "Pre-matched route with request method",
+			Options: func(router chi.Router) []Option {
+				return []Option{
+					WithServerNameInSpanName(true),
+					WithRequestMethodInSpanName(true),
+					WithChiRoutes(router),
+				}
+			},
+			Path:         "/charges/123",
+			ExpectedName: "payments-api: GET /charges/{id}",
+		},
+		{
+			Name: "With span name formatter",
+			Options: func(router chi.Router) []Option {
+				return []Option{
+					WithServerNameInSpanName(true),
+					WithSpanNameFormatter(func(method, routePattern string, r *http.Request) string {
+						return strings.ToLower(method) + " " + routePattern
+					}),
+				}
+			},
+			Path:         "/charges/123",
+			ExpectedName: "payments-api: get /charges/{id}",
+		},
+		{
+			Name: "Unmatched route",
+			Options: func(router chi.Router) []Option {
+				return []Option{WithServerNameInSpanName(true)}
+			},
+			Path:         "/unknown",
+			ExpectedName: "payments-api: unmatched",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("payments-api", append(testCase.Options(router), WithTracerProvider(provider))...))
+			router.HandleFunc("/charges/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", testCase.Path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				testCase.ExpectedName,
+				trace.SpanKindServer,
+				attribute.String("http.server_name", "payments-api"),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithTrailerStatus(t *testing.T) {
+	grpcStatus := func(value string) (codes.Code, string) {
+		if value == "0" {
+			return codes.Unset, ""
+		}
+		return codes.Error, "grpc-status " + value
+	}
+	testCases := []struct {
+		Name            string
+		Handler         http.HandlerFunc
+		ExpectedStatus  codes.Code
+		ExpectedMessage string
+	}{
+		{
+			Name: "Declared trailer",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte("data"))
+				w.Header().Set("Grpc-Status", "13")
+			},
+			ExpectedStatus:  codes.Error,
+			ExpectedMessage: "grpc-status 13",
+		},
+		{
+			Name: "Trailer with prefix",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set(http.TrailerPrefix+"Grpc-Status", "5")
+			},
+			ExpectedStatus:  codes.Error,
+			ExpectedMessage: "grpc-status 5",
+		},
+		{
+			Name: "Successful status",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "Grpc-Status")
+				w.WriteHeader(http.StatusOK)
+				w.Header().Set("Grpc-Status", "0")
+			},
+			ExpectedStatus: codes.Unset,
+		},
+		{
+			Name: "Trailer not set",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			ExpectedStatus: codes.Error,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware(
+				"foobar",
+				WithTracerProvider(provider),
+				WithTrailerStatus("grpc-status", grpcStatus),
+			))
+			router.HandleFunc("/pkg.Service/Method", testCase.Handler)
+
+			r0 := httptest.NewRequest("POST", "/pkg.Service/Method", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, testCase.ExpectedStatus, sr.Ended()[0].Status().Code)
+			assert.Equal(t, testCase.ExpectedMessage, sr.Ended()[0].Status().Description)
+		})
+	}
+}
+
+func TestSDKIntegrationWithTrimTrailingSlash(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithMeterProvider(meterProvider),
+		WithTrimTrailingSlash(true),
+	))
+	router.HandleFunc("/users", ok)
+	router.HandleFunc("/users/", ok)
+	router.With(func(next http.Handler) http.Handler {
+		// a route tag with trailing slash is normalized as well
+		return WithRouteTag("/tagged/", next)
+	}).HandleFunc("/tagged", ok)
+	router.HandleFunc("/", ok)
+
+	for _, path := range []string{"/users", "/users/", "/tagged", "/"} {
+		r0 := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r0)
+		// the routing is not affected
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	require.Len(t, sr.Ended(), 4)
+	assert.Equal(t, "/users", sr.Ended()[0].Name())
+	assert.Equal(t, "/users", sr.Ended()[1].Name())
+	assert.Equal(t, "/tagged", sr.Ended()[2].Name())
+	assert.Equal(t, "/", sr.Ended()[3].Name())
+
+	// both requests collapse into the same metric series
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 4)
+	assert.Equal(t, durations[0].attrs, durations[1].attrs)
+	assertMetricAttributes(t, durations[0], attribute.String("id", "/users"))
+	assertMetricAttributes(t, durations[3], attribute.String("id", "/"))
+}
+
+func TestTraceIDAndSpanIDFromContext(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	var (
+		traceID, spanID     string
+		traceIDOK, spanIDOK bool
+	)
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar", WithTracerProvider(provider)))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		traceID, traceIDOK = TraceIDFromContext(r.Context())
+		spanID, spanIDOK = SpanIDFromContext(r.Context())
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	assert.True(t, traceIDOK)
+	assert.True(t, spanIDOK)
+	assert.Equal(t, sr.Ended()[0].SpanContext().TraceID().String(), traceID)
+	assert.Equal(t, sr.Ended()[0].SpanContext().SpanID().String(), spanID)
+
+	// no recording span
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	_, ok := TraceIDFromContext(ctx)
+	assert.False(t, ok)
+	_, ok = SpanIDFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestSDKIntegrationWithRoutePatternTransformer(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	var patterns []string
+	router := chi.NewRouter()
+	api := chi.NewRouter()
+	api.Get("/users/{id:[0-9]+}", ok)
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithMeterProvider(meterProvider),
+		WithChiRoutes(router),
+		WithRoutePatternTransformer(func(pattern string) string {
+			patterns = append(patterns, pattern)
+			return strings.Replace(pattern, ":[0-9]+", "", -1)
+		}),
+	))
+	router.Mount("/api", api)
+
+	r0 := httptest.NewRequest("GET", "/api/users/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// called once with the full pattern
+	assert.Equal(t, []string{"/api/users/{id:[0-9]+}"}, patterns)
+	require.Len(t, sr.Ended(), 1)
+	assertSpan(t, sr.Ended()[0],
+		"/api/users/{id}",
+		trace.SpanKindServer,
+		attribute.String("http.route", "/api/users/{id}"),
+	)
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.String("id", "/api/users/{id}"))
+}
+
+func TestSDKIntegrationWithSpanOptions(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	router := chi.NewRouter()
+	router.Use(Middleware(
+		"foobar",
+		WithTracerProvider(provider),
+		WithSpanOptions(
+			trace.WithLinks(trace.Link{SpanContext: sc}),
+			trace.WithAttributes(attribute.String("deployment.region", "eu-west-1")),
+			trace.WithTimestamp(start),
+			trace.WithSpanKind(trace.SpanKindInternal),
+		),
+	))
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assert.Equal(t, trace.SpanKindInternal, span.SpanKind())
+	assert.Contains(t, span.Attributes(), attribute.String("deployment.region", "eu-west-1"))
+	assert.Contains(t, span.Attributes(), attribute.String("http.method", "GET"))
+	assert.Equal(t, start, span.StartTime())
+	require.Len(t, span.Links(), 1)
+	assert.Equal(t, sc.SpanID(), span.Links()[0].SpanContext.SpanID())
+}
+
+func TestSDKIntegrationWithCORSPreflightSpanName(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Options          []Option
+		Preflight        bool
+		ExpectedName     string
+		ExpectedDuration bool
+	}{
+		{
+			Name:             "Preflight",
+			Options:          []Option{WithCORSPreflightSpanName("CORS preflight")},
+			Preflight:        true,
+			ExpectedName:     "CORS preflight",
+			ExpectedDuration: true,
+		},
+		{
+			Name: "Preflight without duration",
+			Options: []Option{
+				WithCORSPreflightSpanName("CORS preflight"),
+				WithSkipCORSPreflightDuration(true),
+			},
+			Preflight:    true,
+			ExpectedName: "CORS preflight",
+		},
+		{
+			Name: "Options request without preflight header",
+			Options: []Option{
+				WithCORSPreflightSpanName("CORS preflight"),
+				WithSkipCORSPreflightDuration(true),
+			},
+			ExpectedName:     "/user/{id}",
+			ExpectedDuration: true,
+		},
+		{
+			Name:             "Disabled by default",
+			Preflight:        true,
+			ExpectedName:     "/user/{id}",
+			ExpectedDuration: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+			)...))
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("OPTIONS", "/user/123", nil)
+			if testCase.Preflight {
+				r0.Header.Set("Origin", "https://example.com")
+				r0.Header.Set("Access-Control-Request-Method", "PUT")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, testCase.ExpectedName, sr.Ended()[0].Name())
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			if testCase.ExpectedDuration {
+				assert.Len(t, durations, 1)
+			} else {
+				assert.Len(t, durations, 0)
+			}
+			// the other metrics are still recorded
+			assert.Len(t, meterProvider.Measurements("response_size_bytes"), 1)
+		})
+	}
+}
+
+func TestSDKIntegrationWithNormalizeUnknownMethods(t *testing.T) {
+	chi.RegisterMethod("PROPFIND")
+
+	testCases := []struct {
+		Name             string
+		Options          []Option
+		Method           string
+		ExpectedName     string
+		ExpectedMethod   string
+		ExpectedOriginal string
+	}{
+		{
+			Name:           "Standard method",
+			Options:        []Option{WithNormalizeUnknownMethods(true)},
+			Method:         "GET",
+			ExpectedName:   "GET /user/{id}",
+			ExpectedMethod: "GET",
+		},
+		{
+			Name:             "Unknown method",
+			Options:          []Option{WithNormalizeUnknownMethods(true)},
+			Method:           "FOO",
+			ExpectedName:     "_OTHER /user/{id}",
+			ExpectedMethod:   "_OTHER",
+			ExpectedOriginal: "FOO",
+		},
+		{
+			Name:             "Registered method not in the known methods",
+			Options:          []Option{WithNormalizeUnknownMethods(true)},
+			Method:           "PROPFIND",
+			ExpectedName:     "_OTHER /user/{id}",
+			ExpectedMethod:   "_OTHER",
+			ExpectedOriginal: "PROPFIND",
+		},
+		{
+			Name: "Registered method in the known methods",
+			Options: []Option{
+				WithNormalizeUnknownMethods(true),
+				WithKnownMethods("propfind"),
+			},
+			Method:         "PROPFIND",
+			ExpectedName:   "PROPFIND /user/{id}",
+			ExpectedMethod: "PROPFIND",
+		},
+		{
+			Name:           "Disabled by default",
+			Method:         "FOO",
+			ExpectedName:   "FOO /user/{id}",
+			ExpectedMethod: "FOO",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithRequestMethodInSpanName(true),
+				WithSemConvStability(SemConvStabilityHTTPDup),
+			)...))
+			router.Get("/user/{id}", ok)
+			router.MethodFunc("PROPFIND", "/user/{id}", ok)
+
+			r0 := httptest.NewRequest(testCase.Method, "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Equal(t, testCase.ExpectedName, span.Name())
+			assert.Contains(t, span.Attributes(), attribute.String("http.method", testCase.ExpectedMethod))
+			assert.Contains(t, span.Attributes(), attribute.String("http.request.method", testCase.ExpectedMethod))
+			if testCase.ExpectedOriginal != "" {
+				assert.Contains(t, span.Attributes(), attribute.String("http.request.method_original", testCase.ExpectedOriginal))
+			} else {
+				assertSpanNoAttributes(t, span, "http.request.method_original")
+			}
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.String("method", testCase.ExpectedMethod))
+		})
+	}
+}
+
+func TestSDKIntegrationWithStatusOnlyWriter(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithTracerProvider(provider),
+		WithMeterProvider(meterProvider),
+		WithMeasureSize(true),
+		WithResponseContentLengthAttribute(true),
+	))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, isFlusher := w.(http.Flusher)
+		assert.True(t, isFlusher)
+		w.Write([]byte("hello"))
+	})
+	router.HandleFunc("/book/{title}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	router.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/user/123", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/book/foo", nil))
+	assert.Panics(t, func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	})
+
+	require.Len(t, sr.Ended(), 3)
+	assertSpan(t, sr.Ended()[0],
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusOK),
+		attribute.Int("http.response_content_length", 5),
+	)
+	assertSpan(t, sr.Ended()[1],
+		"/book/{title}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusTeapot),
+	)
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 3)
+	assertMetricAttributes(t, durations[0], attribute.Int("code", http.StatusOK))
+	assertMetricAttributes(t, durations[1], attribute.Int("code", http.StatusTeapot))
+	assertMetricAttributes(t, durations[2], attribute.Int("code", http.StatusInternalServerError))
+}
+
+func TestSDKIntegrationWithHeadAsGet(t *testing.T) {
+	testCases := []struct {
+		Name       string
+		Options    []Option
+		ExpectedID string
+	}{
+		{
+			Name:       "Active",
+			Options:    []Option{WithHeadAsGet(true)},
+			ExpectedID: "/user/{id}",
+		},
+		{
+			Name:       "Disabled by default",
+			ExpectedID: "/user/123",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(chimiddleware.GetHead)
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithChiRoutes(router),
+				WithRequestMethodInSpanName(true),
+			)...))
+			router.Get("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("HEAD", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assertSpan(t, sr.Ended()[0],
+				"HEAD /user/{id}",
+				trace.SpanKindServer,
+				attribute.String("http.method", "HEAD"),
+				attribute.String("http.route", "/user/{id}"),
+				attribute.Int("http.status_code", http.StatusOK),
+			)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0],
+				attribute.String("id", testCase.ExpectedID),
+				attribute.String("method", "HEAD"),
+			)
+		})
+	}
+}
+
+func TestSDKIntegrationWithStrictStatusMapping(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		Options        []Option
+		Status         int
+		ExpectedStatus codes.Code
+	}{
+		{
+			Name:           "Bad request",
+			Options:        []Option{WithStrictStatusMapping(true)},
+			Status:         http.StatusBadRequest,
+			ExpectedStatus: codes.Unset,
+		},
+		{
+			Name:           "Not found",
+			Options:        []Option{WithStrictStatusMapping(true)},
+			Status:         http.StatusNotFound,
+			ExpectedStatus: codes.Unset,
+		},
+		{
+			Name:           "Client closed request",
+			Options:        []Option{WithStrictStatusMapping(true)},
+			Status:         499,
+			ExpectedStatus: codes.Unset,
+		},
+		{
+			Name:           "Internal server error",
+			Options:        []Option{WithStrictStatusMapping(true)},
+			Status:         http.StatusInternalServerError,
+			ExpectedStatus: codes.Error,
+		},
+		{
+			Name:           "Error status codes",
+			Options:        []Option{WithStrictStatusMapping(true), WithErrorStatusCodes(http.StatusTooManyRequests)},
+			Status:         http.StatusTooManyRequests,
+			ExpectedStatus: codes.Error,
+		},
+		{
+			Name:           "Disabled by default",
+			Status:         http.StatusNotFound,
+			ExpectedStatus: codes.Error,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.Status)
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, testCase.ExpectedStatus, sr.Ended()[0].Status().Code)
+		})
+	}
+}
+
+func TestSDKIntegrationWithHijackedConnection(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Options []Option
+	}{
+		{
+			Name: "Default",
+		},
+		{
+			Name:    "Size not measured",
+			Options: []Option{WithMeasureSize(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append([]Option{
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+			}, testCase.Options...)...))
+			router.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+				_, _, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+			})
+
+			r0 := httptest.NewRequest("GET", "/ws", nil)
+			w := &testResponseWriter{
+				writer: httptest.NewRecorder(),
+			}
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Contains(t, span.Attributes(), attribute.Bool("http.connection.hijacked", true))
+			assertSpanNoAttributes(t, span, "http.status_code", "http.response_content_length")
+			assert.Equal(t, codes.Unset, span.Status().Code)
+
+			assert.Len(t, meterProvider.Measurements("request_duration_seconds"), 1)
+			assert.Empty(t, meterProvider.Measurements("response_size_bytes"))
+		})
+	}
+}
+
+func TestSDKIntegrationWithStreamingResponse(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithTracerProvider(provider),
+		WithMeterProvider(meterProvider),
+		WithResponseContentLengthAttribute(true),
+	))
+	router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	r0 := httptest.NewRequest("GET", "/events", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// every write is counted, not only the first one
+	require.Len(t, sr.Ended(), 1)
+	assert.Contains(t, sr.Ended()[0].Attributes(), attribute.Int64("http.response_content_length", 27))
+
+	sizes := meterProvider.Measurements("response_size_bytes")
+	require.Len(t, sizes, 1)
+	assert.Equal(t, int64(27), sizes[0].value)
+}
+
+func TestBytesWrittenFromContextWhileStreaming(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Options []Option
+	}{
+		{
+			Name: "Default",
+		},
+		{
+			Name:    "Size not measured",
+			Options: []Option{WithMeasureSize(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			var flushed []int64
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", testCase.Options...))
+			router.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for i := 0; i < 3; i++ {
+					fmt.Fprintf(w, "data: %d\n\n", i)
+					w.(http.Flusher).Flush()
+					n, _ := BytesWrittenFromContext(r.Context())
+					flushed = append(flushed, n)
+				}
+			})
+
+			r0 := httptest.NewRequest("GET", "/events", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			// the bytes of every write are counted as soon as they are sent
+			assert.Equal(t, []int64{9, 18, 27}, flushed)
+		})
+	}
+}
+
+func TestSDKIntegrationWithSpanStatusMapper(t *testing.T) {
+	mapper := func(status int) (codes.Code, string) {
+		switch {
+		case status == http.StatusTooManyRequests:
+			return codes.Error, "rate limited"
+		case status == 499:
+			return codes.Unset, ""
+		case status >= 500:
+			return codes.Error, ""
+		}
+		return codes.Ok, ""
+	}
+
+	testCases := []struct {
+		Name                string
+		Handler             http.HandlerFunc
+		ExpectedStatus      codes.Code
+		ExpectedDescription string
+	}{
+		{
+			Name: "Too many requests",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			ExpectedStatus:      codes.Error,
+			ExpectedDescription: "rate limited",
+		},
+		{
+			Name: "Client closed request",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(499)
+			},
+			ExpectedStatus: codes.Unset,
+		},
+		{
+			Name: "Bad gateway",
+			Handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			ExpectedStatus: codes.Error,
+		},
+		{
+			Name:           "Implicit OK",
+			Handler:        func(w http.ResponseWriter, r *http.Request) {},
+			ExpectedStatus: codes.Ok,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar",
+				WithTracerProvider(provider),
+				WithSpanStatusMapper(mapper),
+			))
+			router.HandleFunc("/user/{id}", testCase.Handler)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			assert.Equal(t, testCase.ExpectedStatus, sr.Ended()[0].Status().Code)
+			assert.Equal(t, testCase.ExpectedDescription, sr.Ended()[0].Status().Description)
+		})
+	}
+}
+
+func TestSDKIntegrationWithClientDisconnects(t *testing.T) {
+	testCases := []struct {
+		Name         string
+		WriteHeader  bool
+		ExpectedCode int
+	}{
+		{
+			Name:         "Nothing written",
+			ExpectedCode: 499,
+		},
+		{
+			Name:         "Status already written",
+			WriteHeader:  true,
+			ExpectedCode: http.StatusOK,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			started := make(chan struct{})
+			done := make(chan struct{})
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar",
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithClientDisconnects(true),
+				WithClientClosedStatus(true),
+			))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				if testCase.WriteHeader {
+					w.WriteHeader(http.StatusOK)
+					w.(http.Flusher).Flush()
+				}
+				close(started)
+				<-r.Context().Done()
+			})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				router.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			req, err := http.NewRequestWithContext(ctx, "GET", server.URL+"/user/123", nil)
+			require.NoError(t, err)
+			go func() {
+				resp, err := http.DefaultClient.Do(req)
+				if err == nil {
+					// the headers are received when the status is
+					// written, the body is pending until the client
+					// goes away
+					io.Copy(ioutil.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			}()
+			<-started
+			cancel()
+			<-done
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Contains(t, span.Attributes(), attribute.Bool("http.client_closed_request", true))
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, "client.disconnected", span.Events()[0].Name)
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.Int("code", testCase.ExpectedCode))
+		})
+	}
+}
+
+func TestSDKIntegrationWithRecordTimeouts(t *testing.T) {
+	testCases := []struct {
+		Name             string
+		Options          []Option
+		TimeoutFirst     bool
+		ExpectedDeadline bool
+	}{
+		{
+			Name:             "Timeout middleware before",
+			TimeoutFirst:     true,
+			ExpectedDeadline: true,
+		},
+		{
+			Name:    "Timeout middleware after",
+			Options: []Option{WithGatewayTimeoutStatus(true)},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			otelware := Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithRecordTimeouts(true),
+			)...)
+			timeout := chimiddleware.Timeout(10 * time.Millisecond)
+
+			router := chi.NewRouter()
+			if testCase.TimeoutFirst {
+				router.Use(timeout, otelware)
+			} else {
+				router.Use(otelware, timeout)
+			}
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+			})
+			router.HandleFunc("/book/{title}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			r1 := httptest.NewRequest("GET", "/book/foo", nil)
+			router.ServeHTTP(httptest.NewRecorder(), r0)
+			router.ServeHTTP(httptest.NewRecorder(), r1)
+
+			require.Len(t, sr.Ended(), 2)
+			span := sr.Ended()[0]
+			assert.Equal(t, codes.Error, span.Status().Code)
+			assert.Equal(t, "deadline exceeded", span.Status().Description)
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, "timeout", span.Events()[0].Name)
+			if testCase.ExpectedDeadline {
+				var timeoutMs float64
+				for _, a := range span.Attributes() {
+					if a.Key == "http.request.timeout_ms" {
+						timeoutMs = a.Value.AsFloat64()
+					}
+				}
+				assert.InDelta(t, 10, timeoutMs, 5)
+			} else {
+				assertSpanNoAttributes(t, span, "http.request.timeout_ms")
+			}
+
+			assert.Empty(t, sr.Ended()[1].Events())
+			assert.Equal(t, codes.Unset, sr.Ended()[1].Status().Code)
+
+			timeouts := meterProvider.Measurements("requests_timeout")
+			require.Len(t, timeouts, 1)
+			assertMetricAttributes(t, timeouts[0], attribute.String("http.route", "/user/{id}"))
+		})
+	}
+}
+
+func TestSDKIntegrationWithGatewayTimeoutStatus(t *testing.T) {
+	// the handler responds with 504 on its own, no deadline is exceeded
+	testCases := []struct {
+		Name             string
+		Options          []Option
+		ExpectedTimedOut bool
+	}{
+		{
+			Name: "Not a timeout by default",
+		},
+		{
+			Name:             "Active",
+			Options:          []Option{WithGatewayTimeoutStatus(true)},
+			ExpectedTimedOut: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options,
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+				WithRecordTimeouts(true),
+			)...))
+			router.HandleFunc("/proxy", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+			})
+
+			r0 := httptest.NewRequest("GET", "/proxy", nil)
+			router.ServeHTTP(httptest.NewRecorder(), r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			timeouts := meterProvider.Measurements("requests_timeout")
+			if !testCase.ExpectedTimedOut {
+				assert.Empty(t, span.Events())
+				assert.NotEqual(t, "deadline exceeded", span.Status().Description)
+				assert.Empty(t, timeouts)
+				return
+			}
+			require.Len(t, span.Events(), 1)
+			assert.Equal(t, "timeout", span.Events()[0].Name)
+			assert.Equal(t, "deadline exceeded", span.Status().Description)
+			assert.Len(t, timeouts, 1)
+		})
+	}
+}
+
+func TestSDKIntegrationWithErrorType(t *testing.T) {
+	testCases := []struct {
+		Name              string
+		Options           []Option
+		Status            int
+		ExpectedErrorType string
+	}{
+		{
+			Name:              "Internal server error",
+			Status:            http.StatusInternalServerError,
+			ExpectedErrorType: "500",
+		},
+		{
+			Name:              "Service unavailable",
+			Status:            http.StatusServiceUnavailable,
+			ExpectedErrorType: "503",
+		},
+		{
+			Name:   "Client error",
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "Success",
+			Status: http.StatusOK,
+		},
+		{
+			Name: "Server error not marked as error",
+			Options: []Option{WithSpanStatusMapper(func(status int) (codes.Code, string) {
+				return codes.Unset, ""
+			})},
+			Status: http.StatusInternalServerError,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+
+			router := chi.NewRouter()
+			router.Use(Middleware("foobar", append(testCase.Options, WithTracerProvider(provider))...))
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(testCase.Status)
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r0)
+
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			if testCase.ExpectedErrorType != "" {
+				assert.Contains(t, span.Attributes(), attribute.String("error.type", testCase.ExpectedErrorType))
+			} else {
+				assertSpanNoAttributes(t, span, "error.type")
+			}
+		})
+	}
+}
+
+func TestSDKIntegrationWithRecoverer(t *testing.T) {
+	testCases := []struct {
+		Name           string
+		RecovererFirst bool
+	}{
+		{Name: "Recoverer before", RecovererFirst: true},
+		{Name: "Recoverer after"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Name, func(t *testing.T) {
+			sr := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider()
+			provider.RegisterSpanProcessor(sr)
+			meterProvider := &testMeterProvider{}
+
+			otelware := Middleware("foobar",
+				WithTracerProvider(provider),
+				WithMeterProvider(meterProvider),
+			)
+			router := chi.NewRouter()
+			if testCase.RecovererFirst {
+				router.Use(chimiddleware.Recoverer, otelware)
+			} else {
+				router.Use(otelware, chimiddleware.Recoverer)
+			}
+			router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+				panic("something went wrong")
+			})
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			w := httptest.NewRecorder()
+			assert.NotPanics(t, func() {
+				router.ServeHTTP(w, r0)
+			})
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+			// the metrics are the same whatever the order
+			require.Len(t, sr.Ended(), 1)
+			span := sr.Ended()[0]
+			assert.Equal(t, codes.Error, span.Status().Code)
+			assert.Contains(t, span.Attributes(), attribute.Int("http.status_code", http.StatusInternalServerError))
+
+			durations := meterProvider.Measurements("request_duration_seconds")
+			require.Len(t, durations, 1)
+			assertMetricAttributes(t, durations[0], attribute.Int("code", http.StatusInternalServerError))
+			inflight := meterProvider.Measurements("requests_inflight")
+			require.Len(t, inflight, 2)
+			assert.Equal(t, int64(-1), inflight[1].value)
+		})
+	}
+}
+
+func TestSDKIntegrationWithHandlerNotWriting(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	meterProvider := &testMeterProvider{}
+
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithTracerProvider(provider),
+		WithMeterProvider(meterProvider),
+	))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Foo", "bar")
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// the server responds with 200
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assertSpan(t, span,
+		"/user/{id}",
+		trace.SpanKindServer,
+		attribute.Int("http.status_code", http.StatusOK),
+	)
+	assert.Equal(t, codes.Unset, span.Status().Code)
+	assert.Empty(t, span.Status().Description)
+
+	durations := meterProvider.Measurements("request_duration_seconds")
+	require.Len(t, durations, 1)
+	assertMetricAttributes(t, durations[0], attribute.Int("code", http.StatusOK))
+}
+
+func TestSDKIntegrationOutsideChiRouter(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	// there is no route at all, the raw path still doesn't end up in the
+	// span name
+	handler := Middleware("foobar", WithTracerProvider(provider))(http.HandlerFunc(ok))
+	r0 := httptest.NewRequest("GET", "/book/123/chapter/4", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r0)
+
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assert.Equal(t, "unmatched", span.Name())
+	assert.Contains(t, span.Attributes(), attribute.String("http.target", "/book/123/chapter/4"))
+}
+
+func TestSDKIntegrationWithRequestLogger(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+
+	var infos []RequestInfo
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithTracerProvider(provider),
+		WithRequestLogger(func(info RequestInfo) {
+			infos = append(infos, info)
+		}),
+	))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	r0 := httptest.NewRequest("POST", "/user/123", nil)
+	r1 := httptest.NewRequest("GET", "/wp-admin/setup.php", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r0)
+	router.ServeHTTP(httptest.NewRecorder(), r1)
+
+	require.Len(t, sr.Ended(), 2)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "POST", infos[0].Method)
+	assert.Equal(t, "/user/123", infos[0].Path)
+	assert.Equal(t, "/user/{id}", infos[0].Route)
+	assert.Equal(t, http.StatusCreated, infos[0].Status)
+	assert.True(t, infos[0].Duration > 0)
+	assert.Equal(t, sr.Ended()[0].SpanContext().TraceID(), infos[0].TraceID)
+	assert.Equal(t, sr.Ended()[0].SpanContext().SpanID(), infos[0].SpanID)
+
+	assert.Equal(t, "/wp-admin/setup.php", infos[1].Path)
+	assert.Empty(t, infos[1].Route)
+	assert.Equal(t, http.StatusNotFound, infos[1].Status)
+}
+
+func TestSDKIntegrationWithPublicEndpointBaggage(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider()
+	provider.RegisterSpanProcessor(sr)
+	propagator := propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+
+	var tenantID string
+	router := chi.NewRouter()
+	router.Use(Middleware("foobar",
+		WithTracerProvider(provider),
+		WithPropagators(propagator),
+		WithPublicEndpoint(true),
+		WithBaggageAttributes("tenant.id"),
+	))
+	router.HandleFunc("/user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		tenantID = baggage.FromContext(r.Context()).Member("tenant.id").Value()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r0 := httptest.NewRequest("GET", "/user/123", nil)
+	propagator.Inject(trace.ContextWithRemoteSpanContext(context.Background(), sc), propagation.HeaderCarrier(r0.Header))
+	r0.Header.Set("Baggage", "tenant.id=acme")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// the remote span context is only linked, the baggage is still extracted
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assert.False(t, span.Parent().IsValid())
+	assert.NotEqual(t, sc.TraceID(), span.SpanContext().TraceID())
+	require.Len(t, span.Links(), 1)
+	assert.Equal(t, sc.TraceID(), span.Links()[0].SpanContext.TraceID())
+	assert.Equal(t, sc.SpanID(), span.Links()[0].SpanContext.SpanID())
+	assert.Contains(t, span.Attributes(), attribute.String("tenant.id", "acme"))
+	assert.Equal(t, "acme", tenantID)
+}
+
+func assertSpan(t *testing.T, span sdktrace.ReadOnlySpan, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) {
+	assert.Equal(t, name, span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+
+	got := make(map[attribute.Key]attribute.Value, len(span.Attributes()))
+	for _, a := range span.Attributes() {
+		got[a.Key] = a.Value
+	}
+	for _, want := range attrs {
+		if !assert.Contains(t, got, want.Key) {
+			continue
+		}
+		assert.Equal(t, got[want.Key], want.Value)
+	}
+}
+
+func assertSpanNoAttributes(t *testing.T, span sdktrace.ReadOnlySpan, keys ...attribute.Key) {
+	for _, a := range span.Attributes() {
+		for _, key := range keys {
+			assert.NotEqual(t, key, a.Key, "unexpected attribute %s", key)
+		}
+	}
+}
+
+func BenchmarkResponseWriterPool(b *testing.B) {
+	benchmarks := []struct {
+		Name string
+		Pool bool
+	}{
+		{Name: "Pooled", Pool: true},
+		{Name: "NotPooled", Pool: false},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.Name, func(b *testing.B) {
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					WithTracerProvider(noop.NewTracerProvider()),
+					WithResponseWriterPool(bm.Pool),
+				),
+			)
+			router.HandleFunc("/user/{id}", ok)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				r0 := httptest.NewRequest("GET", "/user/123", nil)
+				for pb.Next() {
+					w := httptest.NewRecorder()
+					router.ServeHTTP(w, r0)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkTracingDisabled(b *testing.B) {
+	benchmarks := []struct {
+		Name            string
+		TracingDisabled bool
+	}{
+		{Name: "TracingEnabled", TracingDisabled: false},
+		{Name: "TracingDisabled", TracingDisabled: true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.Name, func(b *testing.B) {
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					WithTracerProvider(sdktrace.NewTracerProvider()),
+					WithChiRoutes(router),
+					WithTracingDisabled(bm.TracingDisabled),
+				),
+			)
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r0)
+			}
+		})
+	}
+}
+
+func BenchmarkStatusOnlyWriter(b *testing.B) {
+	benchmarks := []struct {
+		Name    string
+		Options []Option
+	}{
+		{Name: "Httpsnoop", Options: []Option{WithMeasureSize(false)}},
+		{Name: "StatusOnly", Options: []Option{WithMeasureSize(true)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.Name, func(b *testing.B) {
+			router := chi.NewRouter()
+			router.Use(
+				Middleware(
+					"foobar",
+					append(bm.Options,
+						WithTracerProvider(noop.NewTracerProvider()),
+						WithMeterProvider(metricnoop.NewMeterProvider()),
+					)...,
+				),
+			)
+			router.HandleFunc("/user/{id}", ok)
+
+			r0 := httptest.NewRequest("GET", "/user/123", nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r0)
+			}
+		})
 	}
 }
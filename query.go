@@ -0,0 +1,105 @@
+package otelchi
+
+import (
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// queryRedactor rewrites the query string recorded in span attributes.
+type queryRedactor struct {
+	params    map[string]bool
+	dropQuery bool
+	custom    func(rawQuery string) string
+}
+
+func newQueryRedactor(params []string, dropQuery bool, custom func(rawQuery string) string) *queryRedactor {
+	if len(params) == 0 && !dropQuery && custom == nil {
+		return nil
+	}
+	qr := &queryRedactor{
+		params:    make(map[string]bool, len(params)),
+		dropQuery: dropQuery,
+		custom:    custom,
+	}
+	for _, param := range params {
+		qr.params[param] = true
+	}
+	return qr
+}
+
+// redactQuery replaces the values of the redacted params in the given raw
+// query with "REDACTED". The order and encoding of the other params are kept
+// intact. The user provided redactor is applied last.
+func (qr *queryRedactor) redactQuery(rawQuery string) string {
+	if qr.dropQuery {
+		return ""
+	}
+	if rawQuery == "" {
+		return rawQuery
+	}
+	if len(qr.params) > 0 {
+		rawQuery = qr.redactParams(rawQuery)
+	}
+	if qr.custom != nil {
+		rawQuery = qr.custom(rawQuery)
+	}
+	return rawQuery
+}
+
+func (qr *queryRedactor) redactParams(rawQuery string) string {
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		key := pair
+		if j := strings.Index(pair, "="); j >= 0 {
+			key = pair[:j]
+		}
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if qr.params[name] {
+			pairs[i] = key + "=" + redactedValue
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactTarget redacts the query part of the given request target.
+func (qr *queryRedactor) redactTarget(target string) string {
+	i := strings.Index(target, "?")
+	if i < 0 {
+		return target
+	}
+	query := qr.redactQuery(target[i+1:])
+	if query == "" {
+		return target[:i]
+	}
+	return target[:i+1] + query
+}
+
+// redactAttributes rewrites the target & query attributes in place, the
+// request itself is left untouched.
+func (qr *queryRedactor) redactAttributes(attrs []attribute.KeyValue) []attribute.KeyValue {
+	if qr == nil {
+		return attrs
+	}
+	res := attrs[:0]
+	for _, attr := range attrs {
+		switch attr.Key {
+		case semconv.HTTPTargetKey:
+			attr = semconv.HTTPTargetKey.String(qr.redactTarget(attr.Value.AsString()))
+		case semconvstable.URLQueryKey:
+			query := qr.redactQuery(attr.Value.AsString())
+			if query == "" {
+				continue
+			}
+			attr = semconvstable.URLQuery(query)
+		}
+		res = append(res, attr)
+	}
+	return res
+}
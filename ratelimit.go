@@ -0,0 +1,51 @@
+package otelchi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const rateLimitAttributePrefix = "http.ratelimit."
+
+// rateLimitHeaders are the standard rate limit response headers, including
+// the ones from the IETF RateLimit header fields draft.
+var rateLimitHeaders = []string{
+	"Retry-After",
+	"X-Ratelimit-Limit",
+	"X-Ratelimit-Remaining",
+	"X-Ratelimit-Reset",
+	"Ratelimit",
+	"Ratelimit-Limit",
+	"Ratelimit-Remaining",
+	"Ratelimit-Reset",
+	"Ratelimit-Policy",
+}
+
+// isRateLimitStatus reports whether the given status code usually comes with
+// the rate limit headers.
+func isRateLimitStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// rateLimitAttributes returns the span attributes for the rate limit headers
+// present in the given header, e.g `http.ratelimit.x-ratelimit-remaining`.
+// The values are recorded as numbers when they can be parsed as such.
+func rateLimitAttributes(header http.Header) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, name := range rateLimitHeaders {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		key := attribute.Key(rateLimitAttributePrefix + strings.ToLower(name))
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			attrs = append(attrs, key.Int64(n))
+		} else {
+			attrs = append(attrs, key.String(value))
+		}
+	}
+	return attrs
+}
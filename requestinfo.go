@@ -0,0 +1,27 @@
+package otelchi
+
+import (
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// RequestInfo holds the facts about a request handled by the middleware, it
+// is given to the function passed to WithRequestLogger.
+type RequestInfo struct {
+	// Method is the request method
+	Method string
+	// Path is the request path
+	Path string
+	// Route is the resolved route pattern, it is empty when no route
+	// matches the request
+	Route string
+	// Status is the response status code
+	Status int
+	// Duration is the time spent handling the request
+	Duration time.Duration
+	// TraceID & SpanID identify the server span, they are invalid when
+	// tracing is disabled
+	TraceID oteltrace.TraceID
+	SpanID  oteltrace.SpanID
+}
@@ -0,0 +1,46 @@
+package otelchi
+
+import (
+	"context"
+	"net/http"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// WithRouteTag wraps the given handler so the span of the request is named
+// after the given route and gets it as http.route attribute. This is useful
+// for sub-routers or handlers where the chi route pattern doesn't reflect the
+// logical operation. The span is only modified when it is recording.
+//
+// When used under the middleware, the route tag takes precedence over the
+// route pattern resolved by the middleware, for the metrics as well whether
+// the request is sampled or not.
+func WithRouteTag(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rrw := rrwFromContext(r.Context()); rrw != nil {
+			// let the middleware name the span, so its naming options are
+			// respected
+			rrw.routeTag = route
+		} else if span := oteltrace.SpanFromContext(r.Context()); span.IsRecording() {
+			span.SetAttributes(semconv.HTTPRouteKey.String(route))
+			span.SetName(route)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// SetSpanName sets the name of the span of the request carried by the given
+// context. Unlike calling SetName on the span directly, the name is not
+// overridden by the middleware once the handler is done, whether WithChiRoutes
+// is used or not.
+func SetSpanName(ctx context.Context, name string) {
+	span := oteltrace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	if rrw := rrwFromContext(ctx); rrw != nil {
+		rrw.spanName = name
+	}
+	span.SetName(name)
+}
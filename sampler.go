@@ -0,0 +1,45 @@
+package otelchi
+
+import (
+	"context"
+	"crypto/rand"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// ForceSampleKey is set on the start attributes of the spans of the routes
+// given to WithForceSampleRoutes, it is the hint used by
+// sampling.ForceSampleSampler.
+var ForceSampleKey = attribute.Key("otelchi.force_sample")
+
+func newForceSampleRoutes(routes []string) map[string]bool {
+	if len(routes) == 0 {
+		return nil
+	}
+	res := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		res[route] = true
+	}
+	return res
+}
+
+// unsampledContext returns a context carrying a non-recording span with the
+// sampled flag cleared. It keeps the trace of the propagated span context if
+// any, otherwise a new trace is generated so the spans started down the line
+// are part of the same unsampled trace.
+func unsampledContext(ctx context.Context) context.Context {
+	sc := oteltrace.SpanContextFromContext(ctx)
+	traceID := sc.TraceID()
+	if !traceID.IsValid() {
+		_, _ = rand.Read(traceID[:])
+	}
+	var spanID oteltrace.SpanID
+	_, _ = rand.Read(spanID[:])
+	return oteltrace.ContextWithSpanContext(ctx, oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: sc.TraceFlags().WithSampled(false),
+		TraceState: sc.TraceState(),
+	}))
+}
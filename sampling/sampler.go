@@ -0,0 +1,45 @@
+// Package sampling provides the sampler honoring the routes given to
+// otelchi.WithForceSampleRoutes. It lives apart from otelchi so the middleware
+// only depends on the OpenTelemetry API, not on the SDK.
+package sampling
+
+import (
+	"fmt"
+
+	"github.com/riandyrn/otelchi"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// ForceSampleSampler returns a sampler that always samples the spans of the
+// routes given to otelchi.WithForceSampleRoutes, the sampling decision of the
+// other spans is delegated to the given sampler.
+//
+// Since the parent based sampler only consults its root sampler for root
+// spans, use this sampler as the root sampler, e.g:
+//
+//	sdktrace.ParentBased(sampling.ForceSampleSampler(sdktrace.TraceIDRatioBased(0.01)))
+func ForceSampleSampler(sampler sdktrace.Sampler) sdktrace.Sampler {
+	return forceSampleSampler{sampler: sampler}
+}
+
+type forceSampleSampler struct {
+	sampler sdktrace.Sampler
+}
+
+func (s forceSampleSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	for _, attr := range p.Attributes {
+		if attr.Key == otelchi.ForceSampleKey && attr.Value.AsBool() {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.RecordAndSample,
+				Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState(),
+			}
+		}
+	}
+	return s.sampler.ShouldSample(p)
+}
+
+func (s forceSampleSampler) Description() string {
+	return fmt.Sprintf("ForceSampleSampler{%s}", s.sampler.Description())
+}
@@ -0,0 +1,91 @@
+package sampling
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/riandyrn/otelchi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func ok(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestSDKIntegrationWithForceSampleRoutes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(ForceSampleSampler(sdktrace.TraceIDRatioBased(0))),
+	)
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		otelchi.Middleware(
+			"foobar",
+			otelchi.WithTracerProvider(provider),
+			otelchi.WithChiRoutes(router),
+			otelchi.WithForceSampleRoutes([]string{"/checkout/{id}"}),
+		),
+	)
+	router.HandleFunc("/checkout/{id}", ok)
+	router.HandleFunc("/user/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/checkout/123", nil)
+	r1 := httptest.NewRequest("GET", "/user/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+	router.ServeHTTP(w, r1)
+
+	require.Len(t, sr.Ended(), 1)
+	span := sr.Ended()[0]
+	assert.Equal(t, "/checkout/{id}", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Contains(t, span.Attributes(), attribute.String("http.route", "/checkout/{id}"))
+	assert.Contains(t, span.Attributes(), otelchi.ForceSampleKey.Bool(true))
+}
+
+func TestSDKIntegrationWithForceSampleRoutesWithoutChiRoutes(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(ForceSampleSampler(sdktrace.TraceIDRatioBased(0))),
+	)
+	provider.RegisterSpanProcessor(sr)
+
+	router := chi.NewRouter()
+	router.Use(
+		otelchi.Middleware(
+			"foobar",
+			otelchi.WithTracerProvider(provider),
+			otelchi.WithForceSampleRoutes([]string{"/checkout/{id}"}),
+		),
+	)
+	router.HandleFunc("/checkout/{id}", ok)
+
+	r0 := httptest.NewRequest("GET", "/checkout/123", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r0)
+
+	// the route pattern is unknown when the span is created
+	require.Len(t, sr.Ended(), 0)
+}
+
+func TestForceSampleSamplerDelegates(t *testing.T) {
+	sampler := ForceSampleSampler(sdktrace.AlwaysSample())
+	assert.Equal(t, "ForceSampleSampler{AlwaysOnSampler}", sampler.Description())
+
+	res := sampler.ShouldSample(sdktrace.SamplingParameters{ParentContext: context.Background()})
+	assert.Equal(t, sdktrace.RecordAndSample, res.Decision)
+
+	sampler = ForceSampleSampler(sdktrace.NeverSample())
+	res = sampler.ShouldSample(sdktrace.SamplingParameters{ParentContext: context.Background()})
+	assert.Equal(t, sdktrace.Drop, res.Decision)
+}
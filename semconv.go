@@ -0,0 +1,169 @@
+package otelchi
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// SemConvStability specifies which HTTP semantic conventions are used for the
+// emitted span & metric attributes. It mirrors the values of the
+// `OTEL_SEMCONV_STABILITY_OPT_IN` environment variable defined by the
+// OpenTelemetry specification.
+type SemConvStability int
+
+const (
+	// SemConvStabilityDefault emits only the attributes from the old
+	// (pre-stable) HTTP semantic conventions. This is the default.
+	SemConvStabilityDefault SemConvStability = iota
+	// SemConvStabilityHTTPDup emits both the old and the stable HTTP
+	// semantic conventions attributes, this is helpful during migration.
+	SemConvStabilityHTTPDup
+	// SemConvStabilityHTTP emits only the stable HTTP semantic conventions
+	// attributes (`http.request.method`, `http.response.status_code`,
+	// `url.path`, etc...).
+	SemConvStabilityHTTP
+)
+
+func (s SemConvStability) emitOld() bool {
+	return s != SemConvStabilityHTTP
+}
+
+func (s SemConvStability) emitStable() bool {
+	return s == SemConvStabilityHTTPDup || s == SemConvStabilityHTTP
+}
+
+// httpServerStableAttributes returns the span attributes known at the start
+// of the request as specified by the stable HTTP semantic conventions. The
+// http.route attribute is not included since it shares the key with the old
+// conventions, the user agent is not included either since it is configurable.
+func httpServerStableAttributes(r *http.Request) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconvstable.HTTPRequestMethodKey.String(r.Method),
+		semconvstable.URLPathKey.String(r.URL.Path),
+	}
+	if r.URL.RawQuery != "" {
+		attrs = append(attrs, semconvstable.URLQueryKey.String(r.URL.RawQuery))
+	}
+	attrs = append(attrs, semconvstable.URLScheme(requestScheme(r)))
+
+	attrs = append(attrs, serverAddressAttributes(r)...)
+
+	peerAddr, peerPort := splitHostPort(r.RemoteAddr)
+	if peerAddr != "" {
+		attrs = append(attrs, semconvstable.NetworkPeerAddress(peerAddr))
+		if peerPort > 0 {
+			attrs = append(attrs, semconvstable.NetworkPeerPort(peerPort))
+		}
+	}
+
+	clientAddr := peerAddr
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		if i := strings.Index(xff, ","); i >= 0 {
+			xff = xff[:i]
+		}
+		clientAddr = strings.TrimSpace(xff)
+	}
+	if clientAddr != "" {
+		attrs = append(attrs, semconvstable.ClientAddress(clientAddr))
+	}
+
+	if version := protocolVersion(r); version != "" {
+		attrs = append(attrs, semconvstable.NetworkProtocolVersion(version))
+	}
+
+	return attrs
+}
+
+// serverAddressAttributes returns the server.address & server.port attributes
+// derived from the Host header. When the Host header doesn't carry the port,
+// the port of the listener that accepted the connection is used if known.
+func serverAddressAttributes(r *http.Request) []attribute.KeyValue {
+	host, port := splitHostPort(r.Host)
+	if host == "" {
+		return nil
+	}
+	if port == 0 {
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			_, port = splitHostPort(addr.String())
+		}
+	}
+	attrs := []attribute.KeyValue{semconvstable.ServerAddress(host)}
+	if port > 0 {
+		attrs = append(attrs, semconvstable.ServerPort(port))
+	}
+	return attrs
+}
+
+// splitHostPort splits the host and the optional port of the given address,
+// IPv6 brackets are removed from the host. Port is 0 when missing or invalid.
+func splitHostPort(hostport string) (host string, port int) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// there is no port in the address
+		return strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), 0
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 0 {
+		return host, 0
+	}
+	return host, port
+}
+
+// protocolVersion returns the HTTP protocol version in the format
+// expected by the semantic conventions (e.g "1.1", "2").
+func protocolVersion(r *http.Request) string {
+	switch r.ProtoMajor {
+	case 1:
+		return fmt.Sprintf("1.%d", r.ProtoMinor)
+	case 2, 3:
+		return strconv.Itoa(r.ProtoMajor)
+	}
+	return ""
+}
+
+// withoutAttributes removes the attributes with the given keys in place.
+func withoutAttributes(attrs []attribute.KeyValue, keys ...attribute.Key) []attribute.KeyValue {
+	res := attrs[:0]
+outer:
+	for _, attr := range attrs {
+		for _, key := range keys {
+			if attr.Key == key {
+				continue outer
+			}
+		}
+		res = append(res, attr)
+	}
+	return res
+}
+
+// truncateString truncates s to at most maxLen bytes without splitting a
+// multi-byte character, s is returned as is when maxLen is not positive.
+func truncateString(s string, maxLen int) string {
+	if maxLen <= 0 || len(s) <= maxLen {
+		return s
+	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen]
+}
+
+// spanStatusFromHTTPStatusCode returns the span status for the given response
+// status code. When strict is set, the 4xx status codes leave the span status
+// unset as specified for the server spans, even the nonstandard ones which
+// are considered invalid by the semantic conventions helper.
+func spanStatusFromHTTPStatusCode(code int, strict bool) (codes.Code, string) {
+	if strict && code >= 400 && code < 500 {
+		return codes.Unset, ""
+	}
+	return semconv.SpanStatusFromHTTPStatusCode(code)
+}
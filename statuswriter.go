@@ -0,0 +1,140 @@
+package otelchi
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// statusWriter is the lighter writer used instead of httpsnoop when only the
+// status & the size of the response are needed. It records both on the
+// recording response writer as soon as the response is written, so they are
+// readable while the handler is running.
+//
+// Just like the chi WrapResponseWriter, the optional interfaces of the
+// wrapped writer are kept through the variants below, each of them being a
+// single pointer so wrapping the writer doesn't allocate.
+type statusWriter struct {
+	rrw *recordingResponseWriter
+}
+
+// newStatusWriter returns the variant of statusWriter implementing the same
+// optional interfaces as rrw.next for the given HTTP major version.
+func newStatusWriter(rrw *recordingResponseWriter, protoMajor int) http.ResponseWriter {
+	sw := statusWriter{rrw: rrw}
+	_, fl := rrw.next.(http.Flusher)
+	if protoMajor == 2 {
+		_, ps := rrw.next.(http.Pusher)
+		if fl && ps {
+			return http2StatusWriter{sw}
+		}
+	} else {
+		_, hj := rrw.next.(http.Hijacker)
+		_, rf := rrw.next.(io.ReaderFrom)
+		if fl && hj && rf {
+			return http1StatusWriter{sw}
+		}
+		if fl && hj {
+			return flushHijackStatusWriter{sw}
+		}
+		if hj {
+			return hijackStatusWriter{sw}
+		}
+	}
+	if fl {
+		return flushStatusWriter{sw}
+	}
+	return sw
+}
+
+func (w statusWriter) Header() http.Header {
+	return w.rrw.next.Header()
+}
+
+func (w statusWriter) WriteHeader(statusCode int) {
+	if !w.rrw.written {
+		w.rrw.written = true
+		w.rrw.status = statusCode
+	}
+	w.rrw.next.WriteHeader(statusCode)
+}
+
+func (w statusWriter) Write(b []byte) (int, error) {
+	if !w.rrw.written {
+		w.rrw.written = true
+		w.rrw.status = http.StatusOK
+	}
+	n, err := w.rrw.next.Write(b)
+	w.rrw.recordWrite(int64(n), err)
+	return n, err
+}
+
+// Unwrap returns the wrapped writer, it is used by http.ResponseController.
+func (w statusWriter) Unwrap() http.ResponseWriter {
+	return w.rrw.next
+}
+
+func (w statusWriter) flush() {
+	if !w.rrw.written {
+		w.rrw.written = true
+		w.rrw.status = http.StatusOK
+	}
+	w.rrw.next.(http.Flusher).Flush()
+}
+
+func (w statusWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.rrw.next.(http.Hijacker).Hijack()
+	if err == nil {
+		w.rrw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+func (w statusWriter) readFrom(src io.Reader) (int64, error) {
+	if !w.rrw.written {
+		w.rrw.written = true
+		w.rrw.status = http.StatusOK
+	}
+	n, err := w.rrw.next.(io.ReaderFrom).ReadFrom(src)
+	w.rrw.recordWrite(n, err)
+	return n, err
+}
+
+type flushStatusWriter struct{ statusWriter }
+
+func (w flushStatusWriter) Flush() { w.flush() }
+
+type hijackStatusWriter struct{ statusWriter }
+
+func (w hijackStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+type flushHijackStatusWriter struct{ statusWriter }
+
+func (w flushHijackStatusWriter) Flush() { w.flush() }
+
+func (w flushHijackStatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+type http1StatusWriter struct{ statusWriter }
+
+func (w http1StatusWriter) Flush() { w.flush() }
+
+func (w http1StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.hijack()
+}
+
+func (w http1StatusWriter) ReadFrom(src io.Reader) (int64, error) {
+	return w.readFrom(src)
+}
+
+type http2StatusWriter struct{ statusWriter }
+
+func (w http2StatusWriter) Flush() { w.flush() }
+
+func (w http2StatusWriter) Push(target string, opts *http.PushOptions) error {
+	return w.rrw.next.(http.Pusher).Push(target, opts)
+}
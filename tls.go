@@ -0,0 +1,37 @@
+package otelchi
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconvstable "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+var tlsClientCertificatePresentedKey = attribute.Key("tls.client.certificate_presented")
+
+// tlsVersions maps the TLS versions to the values expected by the semantic
+// conventions.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "1.0",
+	tls.VersionTLS11: "1.1",
+	tls.VersionTLS12: "1.2",
+	tls.VersionTLS13: "1.3",
+}
+
+// tlsAttributes returns the span attributes of the TLS connection of the
+// request, the client certificate contents are never recorded.
+func tlsAttributes(r *http.Request) []attribute.KeyValue {
+	if r.TLS == nil {
+		return nil
+	}
+	attrs := []attribute.KeyValue{
+		semconvstable.TLSProtocolNameTLS,
+		semconvstable.TLSCipher(tls.CipherSuiteName(r.TLS.CipherSuite)),
+		tlsClientCertificatePresentedKey.Bool(len(r.TLS.PeerCertificates) > 0),
+	}
+	if version, ok := tlsVersions[r.TLS.Version]; ok {
+		attrs = append(attrs, semconvstable.TLSProtocolVersion(version))
+	}
+	return attrs
+}